@@ -5,37 +5,137 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/gocs/pensive/internal/mailqueue"
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/internal/mediagc"
 	"github.com/gocs/pensive/internal/router"
+	"github.com/gocs/pensive/pkg/token"
 	"github.com/gocs/pensive/tmpl"
 )
 
 func main() {
 	ctx := context.Background()
 
-	r, err := router.New(ctx, &router.Config{
+	config := &router.Config{
 		// sets the session cookie store key
 		SessionKey: getEnv("SESSION_KEY", "soopa-shiikurrets"),
 		// sets the redis localhost and port
 		RedisAddr: getEnv("REDIS_ADDR", "localhost:6380"),
 		// sets the redis password
 		RedisPassword: getEnv("REDIS_PASSWORD", ""),
-		// sets the gmail username as sender
-		GmailEmail: getEnv("GMAIL_EMAIL", "example@example.com"),
-		// sets the gmail app password of sender
-		GmailPassword: getEnv("GMAIL_APP_PASSWORD", ""),
+		// sets where the sessions are kept: "cookie" or "redis"
+		SessionStore: getEnv("SESSION_STORE", "cookie"),
+		// sets the reverse proxies whose forwarded headers are trusted, e.g. "10.0.0.1,172.16.0.0/12"
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+		// sets how mails are sent: "smtp" or "maildir"
+		MailTransport: getEnv("MAIL_TRANSPORT", "smtp"),
+		// sets the sender address of mails
+		MailFrom: getEnv("MAIL_FROM", getEnv("GMAIL_EMAIL", "example@example.com")),
+		// sets the directory mails are written to with the maildir transport
+		MaildirPath: getEnv("MAILDIR_PATH", "maildir"),
+		// sets the smtp server host, defaults to gmail
+		SMTPHost: getEnv("SMTP_HOST", "smtp.gmail.com"),
+		// sets the smtp server port
+		SMTPPort: getEnv("SMTP_PORT", "587"),
+		// sets the smtp username, leave empty if the relay needs no auth
+		SMTPUsername: getEnv("SMTP_USERNAME", getEnv("GMAIL_EMAIL", "example@example.com")),
+		// sets the smtp password; for gmail use an app password
+		SMTPPassword: getEnv("SMTP_PASSWORD", getEnv("GMAIL_APP_PASSWORD", "")),
+		// sets how the smtp connection is secured: "starttls", "tls", or "none"
+		SMTPTLS: getEnv("SMTP_TLS", "starttls"),
+		// sets where the users reach the app, the links in the mails start with it
+		BaseURL: getEnv("APPLICATION_IP", "http://localhost:8000"),
 		// sets the jwt secret key
 		AccessSecret: getEnv("ACCESS_SECRET", "soopa-shiikurrets-too"),
+		// sets the emailed token keys as "kid:secret,kid:secret"; the first one signs, defaults to ACCESS_SECRET
+		TokenKeys: getEnv("TOKEN_KEYS", ""),
+		// sets how long each kind of emailed link is valid, e.g. "30m" or "24h"
+		TokenTTLs: map[token.Purpose]time.Duration{
+			token.PurposeEmailVerify:   getEnvDuration("TOKEN_TTL_EMAIL_VERIFY", 0),
+			token.PurposePasswordReset: getEnvDuration("TOKEN_TTL_PASSWORD_RESET", 0),
+			token.PurposeEmailChange:   getEnvDuration("TOKEN_TTL_EMAIL_CHANGE", 0),
+			token.PurposeInvite:        getEnvDuration("TOKEN_TTL_INVITE", 0),
+		},
+		// sets how attachments are served: "stream" or "presign"
+		MediaDelivery: getEnv("MEDIA_DELIVERY", "stream"),
+		// sets the MIME types that can be uploaded, e.g. "image/png,image/jpeg"; defaults to every known type
+		MediaAllowed: getEnvList("MEDIA_ALLOWED"),
+		// sets the upload size limits per category in MiB
+		MediaMaxSizes: map[string]int64{
+			"image": int64(getEnvInt("MEDIA_MAX_IMAGE_MB", 0)) << 20,
+			"audio": int64(getEnvInt("MEDIA_MAX_AUDIO_MB", 0)) << 20,
+			"video": int64(getEnvInt("MEDIA_MAX_VIDEO_MB", 0)) << 20,
+			"text":  int64(getEnvInt("MEDIA_MAX_TEXT_MB", 0)) << 20,
+		},
+		// sets where attachments are stored: "minio", "seaweedfs", or "fs"
+		BlobBackend: getEnv("BLOB_BACKEND", "minio"),
+		// sets the directory attachments are written to with the fs backend
+		BlobPath: getEnv("BLOB_PATH", "blobs"),
+		// sets the seaweedfs filer url used by the seaweedfs backend
+		SeaweedFilerURL: getEnv("SEAWEED_FILER_URL", "http://127.0.0.1:8888"),
+		// sets the bucket shared by the media of every user
+		MediaBucket: getEnv("MEDIA_BUCKET", "pensive-media"),
+		// sets the default storage quota of the users in MiB and in files, 0 is unlimited
+		StorageQuota: manager.Quota{
+			Bytes:   int64(getEnvInt("STORAGE_QUOTA_MB", 1024)) << 20,
+			Objects: int64(getEnvInt("STORAGE_QUOTA_FILES", 0)),
+		},
 		// sets the minio api endpoint
 		MinioEndpoint: getEnv("MINIO_ENDPOINT", "127.0.0.1:9000"),
 		// sets the minio username
 		MinioUser: getEnv("MINIO_ROOT_USER", "minio"),
 		// sets the minio password or API Key
 		MinioPassword: getEnv("MINIO_ROOT_PASSWORD", "awaawawaaawawa123123xqcCursed"),
+	}
+
+	// the handlers queue the mails and the mail workers deliver them, both through the same queue
+	m, err := manager.NewManager(ctx, config.RedisAddr, config.RedisPassword)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue := mailqueue.New(m.Cmdable, mailqueue.Options{
+		// sets how many times a mail is tried before it is dead-lettered
+		MaxAttempts: getEnvInt("MAIL_MAX_ATTEMPTS", 5),
 	})
+	config.MailQueue = queue
+
+	// the router, the mail workers, and the collector share the redis client and the blob store
+	objs, err := router.NewBlobStore(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r, err := router.New(ctx, config, m.Cmdable, objs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mailer, err := router.NewMailer(config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// sets how many mails are sent at the same time
+	go queue.Run(ctx, mailer, getEnvInt("MAIL_WORKERS", 2))
+
+	// the collector removes the media no post refers to
+	gc := mediagc.New(objs, m.Cmdable, mediagc.Options{
+		Bucket: config.MediaBucket,
+		// sets how often the orphaned media are collected, e.g. "6h"
+		Interval: getEnvDuration("MEDIA_GC_INTERVAL", 6*time.Hour),
+		// sets how old an orphaned media must be to be collected, keep it longer than a day for the resumable uploads
+		Grace: getEnvDuration("MEDIA_GC_GRACE", 48*time.Hour),
+		// sets whether the orphaned media are moved to the quarantine bucket instead of deleted
+		DryRun: getEnv("MEDIA_GC_DRY_RUN", "true") == "true",
+		// sets the bucket the orphaned media are moved to on a dry run
+		Quarantine: getEnv("MEDIA_GC_QUARANTINE_BUCKET", config.MediaBucket+"-quarantine"),
+	})
+	// sets whether the orphaned media are collected at all
+	if getEnv("MEDIA_GC", "on") == "on" {
+		go gc.Run(ctx)
+	}
 
 	http.Handle("/static/", tmpl.AssetsFS())
 	http.Handle("/", r)
@@ -49,3 +149,29 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvList(key string) []string {
+	list := []string{}
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+// migratemedia moves the media from the bucket of each user into the shared media bucket
+// it reads the same environment as the app and can be run again until every user is migrated
+// the media of a user renamed before are in the bucket of their former username, e.g. -user new -former old
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/internal/managerstore"
+	"github.com/gocs/pensive/internal/router"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only report what would be copied and rewritten")
+	only := flag.String("user", "", "only migrate this username")
+	former := flag.String("former", "", "the former usernames of -user, comma separated, whose buckets are migrated too")
+	flag.Parse()
+	if *former != "" && *only == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	formerNames := []string{}
+	for _, name := range strings.Split(*former, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			formerNames = append(formerNames, name)
+		}
+	}
+
+	ctx := context.Background()
+
+	config := &router.Config{
+		RedisAddr:       getEnv("REDIS_ADDR", "localhost:6380"),
+		RedisPassword:   getEnv("REDIS_PASSWORD", ""),
+		BlobBackend:     getEnv("BLOB_BACKEND", "minio"),
+		BlobPath:        getEnv("BLOB_PATH", "blobs"),
+		SeaweedFilerURL: getEnv("SEAWEED_FILER_URL", "http://127.0.0.1:8888"),
+		MediaBucket:     getEnv("MEDIA_BUCKET", "pensive-media"),
+		MinioEndpoint:   getEnv("MINIO_ENDPOINT", "127.0.0.1:9000"),
+		MinioUser:       getEnv("MINIO_ROOT_USER", "minio"),
+		MinioPassword:   getEnv("MINIO_ROOT_PASSWORD", "awaawawaaawawa123123xqcCursed"),
+	}
+
+	m, err := manager.NewManager(ctx, config.RedisAddr, config.RedisPassword)
+	if err != nil {
+		log.Fatal(err)
+	}
+	objs, err := router.NewBlobStore(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !*dryRun {
+		if err := objs.MakeBucket(ctx, config.MediaBucket); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	users, err := manager.GetUsers(ctx, m.Cmdable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	failed := false
+	for _, user := range users {
+		username, err := user.Username(ctx)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *only != "" && username != *only {
+			continue
+		}
+
+		stats, err := managerstore.MigrateMedia(ctx, objs, m.Cmdable, config.MediaBucket, user, formerNames, *dryRun)
+		if err != nil {
+			// the other users can still be migrated, this one is retried on the next run
+			log.Printf("@%s (%d): %v", username, user.ID(), err)
+			failed = true
+			continue
+		}
+		log.Printf("@%s (%d): %d copied, %d already copied, %d posts rewritten, %d missing", username, user.ID(), stats.Copied, stats.Skipped, stats.Posts, stats.Missing)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
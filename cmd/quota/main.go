@@ -0,0 +1,121 @@
+// quota shows or changes the storage quota of a user
+// it reads the same environment as the app, the quota of the users without one of their own is STORAGE_QUOTA_MB and STORAGE_QUOTA_FILES
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/internal/managerstore"
+	"github.com/gocs/pensive/internal/router"
+)
+
+func main() {
+	username := flag.String("user", "", "the username whose quota is shown or changed")
+	mb := flag.Int64("mb", -1, "set the quota of the user in MiB, 0 is unlimited")
+	files := flag.Int64("files", -1, "set the quota of the user in files, 0 is unlimited")
+	reset := flag.Bool("reset", false, "make the user fallback to the default quota")
+	recount := flag.Bool("recount", false, "count the media of the user again, e.g. the ones saved before the usage was tracked")
+	flag.Parse()
+	if *username == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+
+	config := &router.Config{
+		RedisAddr:       getEnv("REDIS_ADDR", "localhost:6380"),
+		RedisPassword:   getEnv("REDIS_PASSWORD", ""),
+		BlobBackend:     getEnv("BLOB_BACKEND", "minio"),
+		BlobPath:        getEnv("BLOB_PATH", "blobs"),
+		SeaweedFilerURL: getEnv("SEAWEED_FILER_URL", "http://127.0.0.1:8888"),
+		MediaBucket:     getEnv("MEDIA_BUCKET", "pensive-media"),
+		MinioEndpoint:   getEnv("MINIO_ENDPOINT", "127.0.0.1:9000"),
+		MinioUser:       getEnv("MINIO_ROOT_USER", "minio"),
+		MinioPassword:   getEnv("MINIO_ROOT_PASSWORD", "awaawawaaawawa123123xqcCursed"),
+		StorageQuota: manager.Quota{
+			Bytes:   getEnvInt64("STORAGE_QUOTA_MB", 1024) << 20,
+			Objects: getEnvInt64("STORAGE_QUOTA_FILES", 0),
+		},
+	}
+
+	m, err := manager.NewManager(ctx, config.RedisAddr, config.RedisPassword)
+	if err != nil {
+		log.Fatal(err)
+	}
+	user, err := manager.GetUserByName(ctx, m.Cmdable, *username)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *recount {
+		objs, err := router.NewBlobStore(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := managerstore.CountUsage(ctx, objs, m.Cmdable, config.MediaBucket, user.ID()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *reset {
+		if err := manager.ResetQuota(ctx, m.Cmdable, user.ID()); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *mb >= 0 || *files >= 0 {
+		q, err := manager.GetQuota(ctx, m.Cmdable, user.ID(), config.StorageQuota)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if *mb >= 0 {
+			q.Bytes = *mb << 20
+		}
+		if *files >= 0 {
+			q.Objects = *files
+		}
+		if err := manager.SetQuota(ctx, m.Cmdable, user.ID(), q); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	q, err := manager.GetQuota(ctx, m.Cmdable, user.ID(), config.StorageQuota)
+	if err != nil {
+		log.Fatal(err)
+	}
+	u, err := manager.GetUsage(ctx, m.Cmdable, user.ID())
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("@%s (%d): %s of %s, %d of %s files", *username, user.ID(),
+		manager.FormatBytes(u.Bytes), limit(q.Bytes, manager.FormatBytes(q.Bytes)), u.Objects, limit(q.Objects, strconv.FormatInt(q.Objects, 10)))
+}
+
+// limit writes a quota, zero is unlimited
+func limit(n int64, s string) string {
+	if n == 0 {
+		return "unlimited"
+	}
+	return s
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvInt64(key string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(key), 10, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
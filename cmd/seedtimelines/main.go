@@ -0,0 +1,39 @@
+// seedtimelines fills the timeline of every user with the latest posts of the user and of the users they follow
+// it is run once after upgrading to the timelines, running it again changes nothing
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/gocs/pensive/internal/manager"
+)
+
+func main() {
+	ctx := context.Background()
+
+	m, err := manager.NewManager(ctx, getEnv("REDIS_ADDR", "localhost:6380"), getEnv("REDIS_PASSWORD", ""))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	users, err := manager.GetUsers(ctx, m.Cmdable)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, user := range users {
+		if err := manager.SeedTimeline(ctx, m.Cmdable, user.ID()); err != nil {
+			log.Fatalf("user %d: %v", user.ID(), err)
+		}
+	}
+	log.Printf("seeded %d timelines", len(users))
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
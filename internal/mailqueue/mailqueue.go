@@ -0,0 +1,257 @@
+// Package mailqueue delivers mails in the background so handlers do not wait for the mail server
+//
+// jobs are kept in redis:
+//
+//	mail:queue       list of jobs ready to be sent
+//	mail:processing  list of jobs taken by a worker
+//	mail:retry       sorted set of failed jobs scored by when they are due again
+//	mail:dead        list of jobs that failed too many times, without their bodies and capped at deadLimit
+package mailqueue
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+	"github.com/gocs/pensive/pkg/mail"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	queueKey      = "mail:queue"
+	processingKey = "mail:processing"
+	retryKey      = "mail:retry"
+	deadKey       = "mail:dead"
+
+	// deadLimit is how many dead-lettered jobs are kept for inspection
+	deadLimit = 1000
+)
+
+// Options configures the retries of the queue, zero values use the defaults
+type Options struct {
+	MaxAttempts int           // attempts before a job is dead-lettered; defaults to 5
+	BaseBackoff time.Duration // wait after the first failure, doubled on every failure; defaults to 30 seconds
+	MaxBackoff  time.Duration // upper bound of the wait between attempts; defaults to an hour
+}
+
+// Queue is a mail.Mailer that enqueues the mail instead of sending it right away
+type Queue struct {
+	c    redis.Cmdable
+	opts Options
+}
+
+// job is a queued mail with its delivery state
+type job struct {
+	Message   mail.Message `json:"message"`
+	Attempts  int          `json:"attempts"`
+	LastError string       `json:"last_error,omitempty"`
+	QueuedAt  time.Time    `json:"queued_at"`
+}
+
+// New creates a queue stored in redis
+func New(c redis.Cmdable, opts Options) *Queue {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.BaseBackoff <= 0 {
+		opts.BaseBackoff = 30 * time.Second
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = time.Hour
+	}
+	return &Queue{c: c, opts: opts}
+}
+
+// Send enqueues the mail to be delivered by the workers
+func (q *Queue) Send(ctx context.Context, msg mail.Message) error {
+	if !govalidator.IsEmail(msg.To) {
+		return mail.ErrNotValidToAddr
+	}
+
+	data, err := json.Marshal(job{Message: msg, QueuedAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	return q.c.LPush(ctx, queueKey, data).Err()
+}
+
+// Run starts the workers sending the queued mails through the mailer and blocks until ctx is done
+// jobs left in processing by a previous run are queued again, so only run a single pool per redis
+func (q *Queue) Run(ctx context.Context, mailer mail.Mailer, workers int) {
+	if err := q.recover(ctx); err != nil {
+		log.Println("mailqueue recover err:", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, mailer)
+		}()
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+			if err := q.promote(ctx); err != nil && ctx.Err() == nil {
+				log.Println("mailqueue promote err:", err)
+			}
+			q.observe(ctx)
+		}
+	}
+}
+
+// recover moves the jobs that were taken but never finished back to the queue
+func (q *Queue) recover(ctx context.Context) error {
+	for {
+		err := q.c.RPopLPush(ctx, processingKey, queueKey).Err()
+		if err == redis.Nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (q *Queue) work(ctx context.Context, mailer mail.Mailer) {
+	for ctx.Err() == nil {
+		data, err := q.c.BRPopLPush(ctx, queueKey, processingKey, time.Second).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println("mailqueue pop err:", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		q.process(ctx, mailer, data)
+	}
+}
+
+// process sends a single job, then either drops, reschedules, or dead-letters it
+func (q *Queue) process(ctx context.Context, mailer mail.Mailer, data string) {
+	// the bookkeeping must finish even when the workers are stopping
+	bg := context.WithoutCancel(ctx)
+	defer func() {
+		if err := q.c.LRem(bg, processingKey, 1, data).Err(); err != nil {
+			log.Println("mailqueue LRem err:", err)
+		}
+	}()
+
+	var j job
+	if err := json.Unmarshal([]byte(data), &j); err != nil {
+		log.Println("mailqueue Unmarshal err:", err)
+		q.deadLetter(bg, job{LastError: "unreadable job: " + err.Error(), QueuedAt: time.Now().UTC()})
+		return
+	}
+
+	err := mailer.Send(ctx, j.Message)
+	if err == nil {
+		Sent.Inc()
+		return
+	}
+	Failures.Inc()
+
+	j.Attempts++
+	j.LastError = err.Error()
+	if j.Attempts >= q.opts.MaxAttempts {
+		log.Printf("mailqueue: giving up on mail to %s after %d attempts: %v", j.Message.To, j.Attempts, err)
+		q.deadLetter(bg, j)
+		return
+	}
+
+	next, merr := json.Marshal(j)
+	if merr != nil {
+		log.Println("mailqueue Marshal err:", merr)
+		return
+	}
+
+	due := time.Now().Add(q.backoff(j.Attempts))
+	if err := q.c.ZAdd(bg, retryKey, redis.Z{Score: float64(due.Unix()), Member: next}).Err(); err != nil {
+		log.Println("mailqueue ZAdd err:", err)
+	}
+}
+
+// deadLetter keeps the job that is given up on, without its body
+// the bodies hold the links of live tokens, e.g. password resets, which must not linger in redis
+func (q *Queue) deadLetter(ctx context.Context, j job) {
+	j.Message.Text, j.Message.HTML = "", ""
+	data, err := json.Marshal(j)
+	if err != nil {
+		log.Println("mailqueue Marshal err:", err)
+		return
+	}
+
+	pipe := q.c.Pipeline()
+	pipe.LPush(ctx, deadKey, data)
+	pipe.LTrim(ctx, deadKey, 0, deadLimit-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Println("mailqueue dead-letter err:", err)
+	}
+	DeadLetters.Inc()
+}
+
+// backoff is the wait before the next attempt after the given number of failed attempts
+func (q *Queue) backoff(attempts int) time.Duration {
+	wait := q.opts.BaseBackoff
+	for i := 1; i < attempts && wait < q.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > q.opts.MaxBackoff {
+		wait = q.opts.MaxBackoff
+	}
+	return wait
+}
+
+// promote moves the retries that are due back to the queue
+func (q *Queue) promote(ctx context.Context) error {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	due, err := q.c.ZRangeByScore(ctx, retryKey, &redis.ZRangeBy{Min: "-inf", Max: now}).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, data := range due {
+		// only the one that removed it from the retries gets to queue it
+		removed, err := q.c.ZRem(ctx, retryKey, data).Result()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			continue
+		}
+		if err := q.c.LPush(ctx, queueKey, data).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// observe updates the depth gauges
+func (q *Queue) observe(ctx context.Context) {
+	pipe := q.c.Pipeline()
+	queued := pipe.LLen(ctx, queueKey)
+	processing := pipe.LLen(ctx, processingKey)
+	retry := pipe.ZCard(ctx, retryKey)
+	dead := pipe.LLen(ctx, deadKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return
+	}
+
+	Depth.WithLabelValues("queued").Set(float64(queued.Val()))
+	Depth.WithLabelValues("processing").Set(float64(processing.Val()))
+	Depth.WithLabelValues("retry").Set(float64(retry.Val()))
+	Depth.WithLabelValues("dead").Set(float64(dead.Val()))
+}
@@ -0,0 +1,26 @@
+package mailqueue
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// the collectors are registered by the router alongside the http metrics
+var (
+	Sent = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mail_sent_total",
+		Help: "Number of mails delivered by the queue.",
+	})
+
+	Failures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mail_send_failures_total",
+		Help: "Number of failed mail delivery attempts.",
+	})
+
+	DeadLetters = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "mail_dead_letters_total",
+		Help: "Number of mails given up after too many failed attempts.",
+	})
+
+	Depth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "mail_queue_depth",
+		Help: "Number of mails in the queue by state.",
+	}, []string{"state"})
+)
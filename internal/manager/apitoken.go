@@ -0,0 +1,211 @@
+package manager
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocs/pensive/pkg/timelayout"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// ScopeRead allows the token to view pages and api resources
+	ScopeRead = "read"
+	// ScopeWrite allows the token to create or modify resources
+	ScopeWrite = "write"
+
+	// apiTokenPrefix marks the raw token so it is easy to spot when leaked
+	apiTokenPrefix = "pensive_"
+)
+
+// APIToken is a personal access token of the user, the raw token is never stored
+type APIToken struct {
+	ID         int64
+	Name       string
+	Scopes     []string
+	CreatedAt  *time.Time
+	LastUsedAt *time.Time // nil if the token is never used
+}
+
+func apiTokenKey(tokenID int64) string  { return fmt.Sprintf("apitoken:%d", tokenID) }
+func userTokensKey(userID int64) string { return fmt.Sprintf("user:%d:apitokens", userID) }
+
+func hashAPIToken(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken mints a new named token for the user
+// returns the raw token which must be shown to the user only once
+func CreateAPIToken(ctx context.Context, c redis.Cmdable, userID int64, name string, scopes []string) (string, error) {
+	if name == "" || len(scopes) == 0 {
+		return "", ErrEmptyForm
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return "", ErrInvalidScope
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	id, err := c.Incr(ctx, "apitoken:next-id").Result()
+	if err != nil {
+		return "", err
+	}
+
+	// set created at date
+	now := time.Now().UTC().String()
+
+	hash := hashAPIToken(raw)
+	key := apiTokenKey(id)
+	pipe := c.Pipeline()
+	pipe.HSet(ctx, key, "id", id)
+	pipe.HSet(ctx, key, "user_id", userID)
+	pipe.HSet(ctx, key, "name", name)
+	pipe.HSet(ctx, key, "hash", hash)
+	pipe.HSet(ctx, key, "scopes", strings.Join(scopes, ","))
+	pipe.HSet(ctx, key, "created_at", now)
+	pipe.HSet(ctx, "apitoken:by-hash", hash, id)
+	pipe.SAdd(ctx, userTokensKey(userID), id)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// ListAPITokens lists the tokens of the user ordered by creation
+func ListAPITokens(ctx context.Context, c redis.Cmdable, userID int64) ([]APIToken, error) {
+	members, err := c.SMembers(ctx, userTokensKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := []APIToken{}
+	for _, member := range members {
+		id, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		fields, err := c.HGetAll(ctx, apiTokenKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		createdAt, err := time.Parse(timelayout.UTCLayout, fields["created_at"])
+		if err != nil {
+			return nil, err
+		}
+
+		t := APIToken{
+			ID:        id,
+			Name:      fields["name"],
+			Scopes:    strings.Split(fields["scopes"], ","),
+			CreatedAt: &createdAt,
+		}
+		if fields["last_used_at"] != "" {
+			lastUsedAt, err := time.Parse(timelayout.UTCLayout, fields["last_used_at"])
+			if err != nil {
+				return nil, err
+			}
+			t.LastUsedAt = &lastUsedAt
+		}
+		tokens = append(tokens, t)
+	}
+
+	slices.SortFunc(tokens, func(a, b APIToken) int { return cmp.Compare(a.ID, b.ID) })
+	return tokens, nil
+}
+
+// RevokeAPIToken deletes the token so it can no longer be used
+// userID is the current user requesting and must own the token
+func RevokeAPIToken(ctx context.Context, c redis.Cmdable, userID, tokenID int64) error {
+	key := apiTokenKey(tokenID)
+	fields, err := c.HMGet(ctx, key, "user_id", "hash").Result()
+	if err != nil {
+		return err
+	}
+	if fields[0] == nil {
+		return ErrInvalidToken
+	}
+	if fmt.Sprint(fields[0]) != fmt.Sprint(userID) {
+		return ErrPerm
+	}
+
+	pipe := c.Pipeline()
+	pipe.Del(ctx, key)
+	pipe.HDel(ctx, "apitoken:by-hash", fmt.Sprint(fields[1]))
+	pipe.SRem(ctx, userTokensKey(userID), tokenID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// AuthAPIToken gets the owner of the raw token if the token has the required scope
+func AuthAPIToken(ctx context.Context, c redis.Cmdable, raw, scope string) (*User, error) {
+	id, err := c.HGet(ctx, "apitoken:by-hash", hashAPIToken(raw)).Int64()
+	if err == redis.Nil {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	key := apiTokenKey(id)
+	fields, err := c.HMGet(ctx, key, "user_id", "scopes").Result()
+	if err != nil {
+		return nil, err
+	}
+	if fields[0] == nil {
+		return nil, ErrInvalidToken
+	}
+
+	userID, err := strconv.ParseInt(fmt.Sprint(fields[0]), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Contains(strings.Split(fmt.Sprint(fields[1]), ","), scope) {
+		return nil, ErrTokenScope
+	}
+
+	now := time.Now().UTC().String()
+	if err := c.HSet(ctx, key, "last_used_at", now).Err(); err != nil {
+		return nil, err
+	}
+	return &User{id: userID, c: c}, nil
+}
+
+// bearerToken gets the token from the "Authorization: Bearer <token>" header
+func bearerToken(r *http.Request) (string, bool) {
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || raw == "" {
+		return "", false
+	}
+	return strings.TrimSpace(raw), true
+}
+
+// requiredScope is the token scope needed for the request method
+func requiredScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return ScopeRead
+	}
+	return ScopeWrite
+}
@@ -18,6 +18,57 @@ var (
 	// ErrEmptyForm gives error message when form body is empty
 	ErrEmptyForm = errors.New("form is empty")
 
+	// ErrPostNotFound gives error message when the post does not exist
+	ErrPostNotFound = errors.New("post not found")
+
+	// ErrFollowSelf gives error message when the user tries to follow themself
+	ErrFollowSelf = errors.New("cannot follow yourself")
+
+	// ErrInvalidToken gives error message when the api token is unknown or revoked
+	ErrInvalidToken = errors.New("api token is invalid")
+
+	// ErrInvalidScope gives error message when the api token is minted with an unknown scope
+	ErrInvalidScope = errors.New("api token scope is invalid")
+
+	// ErrTokenScope gives error message when the api token lacks the scope the request needs
+	ErrTokenScope = errors.New("api token does not have the required scope")
+
+	// ErrSameEmail gives error message when the new email is the same as the current one
+	ErrSameEmail = errors.New("email is the same as the current one")
+
+	// ErrNoPendingEmail gives error message when there is no email change to be confirmed
+	ErrNoPendingEmail = errors.New("no email change is pending")
+
+	// ErrTooManyAttachments gives error message when a post has more than MaxAttachments media
+	ErrTooManyAttachments = errors.New("post has too many attachments")
+
+	// ErrAltRequired gives error message when a picture is posted without alt text by a user who requires it
+	ErrAltRequired = errors.New("pictures need an alt text")
+
+	// ErrAltTooLong gives error message when the alt text is longer than MaxAltLength
+	ErrAltTooLong = errors.New("alt text is too long")
+
+	// ErrUploadNotFound gives error message when the resumable upload does not exist, expired, or belongs to someone else
+	ErrUploadNotFound = errors.New("upload not found")
+
+	// ErrUploadOffset gives error message when a chunk does not start where the upload stopped
+	ErrUploadOffset = errors.New("upload offset does not match")
+
+	// ErrUploadLocked gives error message when a chunk is sent while another one of the same upload is being written
+	ErrUploadLocked = errors.New("upload is already being written")
+
+	// ErrUploadIncomplete gives error message when an upload is attached to a post before all of it is received
+	ErrUploadIncomplete = errors.New("upload is not complete")
+
+	// ErrUploadPicture gives error message when a picture is sent as a resumable upload, pictures are posted with the form
+	ErrUploadPicture = errors.New("pictures cannot be sent as resumable uploads")
+
+	// ErrUploadUnsupported gives error message when the blob store cannot assemble uploads from parts
+	ErrUploadUnsupported = errors.New("resumable uploads are not supported by the storage")
+
+	// ErrQuotaExceeded gives error message when saving the media would take more storage than the quota of the user
+	ErrQuotaExceeded = errors.New("storage quota exceeded")
+
 	// ErrPerm gives error message when self tries to modify other accounts details
 	ErrPerm = errors.New("permission to modify is denied")
 )
@@ -0,0 +1,194 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func followersKey(userID int64) string { return fmt.Sprintf("user:%d:followers", userID) }
+func followingKey(userID int64) string { return fmt.Sprintf("user:%d:following", userID) }
+func timelineKey(userID int64) string  { return fmt.Sprintf("user:%d:timeline", userID) }
+
+// Follow makes the user follow the target user
+// the latest posts of the target are merged into the user's timeline, the newer ones are fanned out to it
+func Follow(ctx context.Context, c redis.Cmdable, userID, targetID int64) error {
+	if userID == targetID {
+		return ErrFollowSelf
+	}
+
+	pipe := c.Pipeline()
+	pipe.SAdd(ctx, followingKey(userID), targetID)
+	pipe.SAdd(ctx, followersKey(targetID), userID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	return backfillTimeline(ctx, c, userID, targetID)
+}
+
+// timelineBackfill is how many of the latest posts of an author are merged into a timeline
+const timelineBackfill = 200
+
+// timelineCap is how many of the latest posts a timeline keeps
+const timelineCap = 1000
+
+// mergeTimeline merges the post ids of ARGV[2:] into the timeline, keeping the ARGV[1] newest without duplicates
+// it runs as a script so the posts fanned out meanwhile are not lost, only the capped head is read and rewritten
+var mergeTimeline = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local seen, ids = {}, {}
+local function add(id)
+	if not seen[id] then
+		seen[id] = true
+		table.insert(ids, id)
+	end
+end
+for _, id in ipairs(redis.call('LRANGE', KEYS[1], 0, limit - 1)) do add(id) end
+for i = 2, #ARGV do add(ARGV[i]) end
+-- the ids stay strings since lua writes large numbers in exponent notation
+table.sort(ids, function(a, b) return tonumber(a) > tonumber(b) end)
+local n = math.min(#ids, limit)
+redis.call('DEL', KEYS[1])
+for i = 1, n, 1000 do
+	redis.call('RPUSH', KEYS[1], unpack(ids, i, math.min(i + 999, n)))
+end
+return n
+`)
+
+// backfillTimeline merges the latest posts of the authors into the user's timeline
+func backfillTimeline(ctx context.Context, c redis.Cmdable, userID int64, authorIDs ...int64) error {
+	ids := []interface{}{timelineCap}
+	for _, authorID := range authorIDs {
+		posts, err := c.LRange(ctx, fmt.Sprintf("user:%d:posts", authorID), 0, timelineBackfill-1).Result()
+		if err != nil {
+			return err
+		}
+		for _, id := range posts {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 1 {
+		return nil
+	}
+	return mergeTimeline.Run(ctx, c, []string{timelineKey(userID)}, ids...).Err()
+}
+
+// SeedTimeline merges the latest posts of the user and of the users they follow into their timeline
+// the timelines of the users who posted before there were timelines are seeded this way
+func SeedTimeline(ctx context.Context, c redis.Cmdable, userID int64) error {
+	following, err := c.SMembers(ctx, followingKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	authorIDs := []int64{userID}
+	for _, member := range following {
+		id, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return err
+		}
+		authorIDs = append(authorIDs, id)
+	}
+	return backfillTimeline(ctx, c, userID, authorIDs...)
+}
+
+// unmergeTimeline removes the post ids of ARGV from the timeline in a single pass over it
+var unmergeTimeline = redis.NewScript(`
+local drop, ids = {}, {}
+for _, id in ipairs(ARGV) do drop[id] = true end
+for _, id in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	if not drop[id] then table.insert(ids, id) end
+end
+redis.call('DEL', KEYS[1])
+for i = 1, #ids, 1000 do
+	redis.call('RPUSH', KEYS[1], unpack(ids, i, math.min(i + 999, #ids)))
+end
+return #ids
+`)
+
+// Unfollow makes the user stop following the target user
+// the posts of the target are removed from the user's timeline, a timeline only holds the latest posts
+// so only that many of the latest posts of the target can be in it
+func Unfollow(ctx context.Context, c redis.Cmdable, userID, targetID int64) error {
+	posts, err := c.LRange(ctx, fmt.Sprintf("user:%d:posts", targetID), 0, timelineCap-1).Result()
+	if err != nil {
+		return err
+	}
+
+	pipe := c.TxPipeline()
+	pipe.SRem(ctx, followingKey(userID), targetID)
+	pipe.SRem(ctx, followersKey(targetID), userID)
+	if len(posts) > 0 {
+		ids := make([]interface{}, len(posts))
+		for i, id := range posts {
+			ids[i] = id
+		}
+		unmergeTimeline.Eval(ctx, pipe, []string{timelineKey(userID)}, ids...)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// IsFollowing checks if the user follows the target user
+func IsFollowing(ctx context.Context, c redis.Cmdable, userID, targetID int64) (bool, error) {
+	return c.SIsMember(ctx, followingKey(userID), targetID).Result()
+}
+
+// FollowerCount counts the users following the user
+func FollowerCount(ctx context.Context, c redis.Cmdable, userID int64) (int64, error) {
+	return c.SCard(ctx, followersKey(userID)).Result()
+}
+
+// FollowingCount counts the users followed by the user
+func FollowingCount(ctx context.Context, c redis.Cmdable, userID int64) (int64, error) {
+	return c.SCard(ctx, followingKey(userID)).Result()
+}
+
+// Followers gets the ids of the users following the user
+func Followers(ctx context.Context, c redis.Cmdable, userID int64) ([]int64, error) {
+	members, err := c.SMembers(ctx, followersKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(members))
+	for i, member := range members {
+		id, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// GetTimeline gets the posts of the user and the users they follow
+// the deleted posts left in the timeline, e.g. by users who unfollowed the author, are dropped from it
+func GetTimeline(ctx context.Context, c redis.Cmdable, userID int64, page Page) ([]*Post, Cursor, error) {
+	posts, cursor, err := queryPosts(ctx, c, timelineKey(userID), page)
+	if err != nil {
+		return nil, Cursor{}, err
+	}
+
+	pipe := c.Pipeline()
+	exists := make([]*redis.IntCmd, len(posts))
+	for i, post := range posts {
+		exists[i] = pipe.Exists(ctx, fmt.Sprintf("post:%d", post.id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, Cursor{}, err
+	}
+
+	kept := make([]*Post, 0, len(posts))
+	for i, post := range posts {
+		if exists[i].Val() == 0 {
+			if err := c.LRem(ctx, timelineKey(userID), 0, post.id).Err(); err != nil {
+				return nil, Cursor{}, err
+			}
+			continue
+		}
+		kept = append(kept, post)
+	}
+	return kept, cursor, nil
+}
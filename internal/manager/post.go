@@ -2,9 +2,11 @@ package manager
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gocs/pensive"
 	"github.com/gocs/pensive/pkg/timelayout"
@@ -18,16 +20,84 @@ type Post struct {
 	id int64
 }
 
+// ID PostID getter
+// there should be no setter for id
+func (p *Post) ID() int64 { return p.id }
+
 // Body getter
 func (p *Post) Body(ctx context.Context) (string, error) {
 	key := fmt.Sprintf("post:%d", p.id)
 	return p.c.HGet(ctx, key, "body").Result()
 }
 
-// MediaID getter
-func (p *Post) MediaID(ctx context.Context) (string, error) {
+// Attachments getter, in the order they were uploaded
+// posts created before they could have several attachments are read from their single media fields
+func (p *Post) Attachments(ctx context.Context) ([]pensive.Media, error) {
 	key := fmt.Sprintf("post:%d", p.id)
-	return p.c.HGet(ctx, key, "media_id").Result()
+	fields, err := p.c.HMGet(ctx, key, "attachments", "media_id", "media_name", "media_size", "media_width", "media_height", "media_variants").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// fields missing from the post are nil
+	str := func(i int) string {
+		s, _ := fields[i].(string)
+		return s
+	}
+
+	attachments := []pensive.Media{}
+	if s := str(0); s != "" {
+		if err := json.Unmarshal([]byte(s), &attachments); err != nil {
+			return nil, err
+		}
+		return attachments, nil
+	}
+
+	m := pensive.Media{ID: str(1), Name: str(2)}
+	if m.ID == "" {
+		return attachments, nil
+	}
+	// the oldest posts were stored under the original filename
+	if m.Name == "" {
+		m.Name = m.ID
+	}
+	if s := str(3); s != "" {
+		if m.Size, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+	if s := str(4); s != "" {
+		if m.Width, err = strconv.Atoi(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := str(5); s != "" {
+		if m.Height, err = strconv.Atoi(s); err != nil {
+			return nil, err
+		}
+	}
+	if s := str(6); s != "" {
+		if err := json.Unmarshal([]byte(s), &m.Variants); err != nil {
+			return nil, err
+		}
+	}
+	return append(attachments, m), nil
+}
+
+// SetAttachments replaces the attachments of the post
+// the single media fields of the oldest posts are dropped in favour of the attachments field
+func (p *Post) SetAttachments(ctx context.Context, attachments []pensive.Media) error {
+	b, err := json.Marshal(attachments)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("post:%d", p.id)
+	pipe := p.c.Pipeline()
+	pipe.HSet(ctx, key, "attachments", b)
+	pipe.HDel(ctx, key, "media_id", "media_name", "media_size", "media_width", "media_height", "media_variants")
+	_, err = pipe.Exec(ctx)
+	return err
 }
 
 // User getter
@@ -78,19 +148,40 @@ func AddPost(ctx context.Context, c redis.Cmdable, p pensive.Post) (*Post, error
 		return nil, err
 	}
 
+	// fan-out the post to the timelines of the followers and the author
+	followers, err := Followers(ctx, c, p.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	// set created at date
 	now := time.Now().UTC().String()
 
+	if p.Attachments == nil {
+		p.Attachments = []pensive.Media{}
+	}
+	attachments, err := json.Marshal(p.Attachments)
+	if err != nil {
+		return nil, err
+	}
+
 	key := fmt.Sprintf("post:%d", id)
 	pipe := c.Pipeline()
 	pipe.HSet(ctx, key, "id", id)
 	pipe.HSet(ctx, key, "user_id", p.User.ID)
 	pipe.HSet(ctx, key, "body", p.Body)
-	pipe.HSet(ctx, key, "media_id", p.MediaID)
+	pipe.HSet(ctx, key, "attachments", attachments)
 	pipe.HSet(ctx, key, "created_at", now)
 	pipe.HSet(ctx, key, "updated_at", now)
 	pipe.LPush(ctx, "posts", id)
 	pipe.LPush(ctx, fmt.Sprintf("user:%d:posts", p.User.ID), id)
+	// the timelines only keep the latest posts, the older ones are listed from the profiles
+	pipe.LPush(ctx, timelineKey(p.User.ID), id)
+	pipe.LTrim(ctx, timelineKey(p.User.ID), 0, timelineCap-1)
+	for _, follower := range followers {
+		pipe.LPush(ctx, timelineKey(follower), id)
+		pipe.LTrim(ctx, timelineKey(follower), 0, timelineCap-1)
+	}
 	_, err = pipe.Exec(ctx)
 	if err != nil {
 		return nil, err
@@ -99,48 +190,265 @@ func AddPost(ctx context.Context, c redis.Cmdable, p pensive.Post) (*Post, error
 	return &Post{id: id, c: c}, nil
 }
 
-func queryPosts(ctx context.Context, c redis.Cmdable, key string) ([]*Post, error) {
-	postIDs, err := c.LRange(ctx, key, 0, 10).Result()
+// DefaultPageSize is the number of posts listed when the page size is unset
+const DefaultPageSize = 20
+
+// MaxPageSize is the upper bound of posts listed in a single page
+const MaxPageSize = 100
+
+// Page selects a window of a post list using post IDs as cursors
+// Before lists the posts older than the given post ID
+// After lists the posts newer than the given post ID
+// if both are zero, the newest posts are listed; Before takes precedence over After
+type Page struct {
+	Before int64
+	After  int64
+	Size   int64
+}
+
+// Cursor points to the neighbouring pages of a listed page
+// a zero value means there is no page in that direction
+type Cursor struct {
+	Older int64 // use as Page.Before to get the next older page
+	Newer int64 // use as Page.After to get the next newer page
+}
+
+func (p Page) size() int64 {
+	if p.Size <= 0 {
+		return DefaultPageSize
+	}
+	if p.Size > MaxPageSize {
+		return MaxPageSize
+	}
+	return p.Size
+}
+
+// indexBelow finds the index of the first post in the list that is older than the cursor
+// post lists are pushed to the head so they are ordered from the newest to the oldest id
+// if inclusive is true, the post with the cursor id itself is counted as older
+func indexBelow(ctx context.Context, c redis.Cmdable, key string, cursor int64, inclusive bool) (int64, error) {
+	pos, err := c.LPos(ctx, key, strconv.FormatInt(cursor, 10), redis.LPosArgs{}).Result()
+	if err == nil {
+		if inclusive {
+			return pos, nil
+		}
+		return pos + 1, nil
+	} else if err != redis.Nil {
+		return 0, err
+	}
+
+	// the cursor post may have been removed, walk the list to find where it would have been
+	const chunk = 100
+	for start := int64(0); ; start += chunk {
+		vals, err := c.LRange(ctx, key, start, start+chunk-1).Result()
+		if err != nil {
+			return 0, err
+		}
+		for i, val := range vals {
+			id, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			if id < cursor || (inclusive && id == cursor) {
+				return start + int64(i), nil
+			}
+		}
+		if len(vals) < chunk {
+			return start + int64(len(vals)), nil
+		}
+	}
+}
+
+func queryPosts(ctx context.Context, c redis.Cmdable, key string, page Page) ([]*Post, Cursor, error) {
+	size := page.size()
+
+	// [start, stop] is the inclusive range of the list to be listed
+	var start, stop int64
+	switch {
+	case page.Before > 0:
+		i, err := indexBelow(ctx, c, key, page.Before, false)
+		if err != nil {
+			return nil, Cursor{}, err
+		}
+		start, stop = i, i+size-1
+	case page.After > 0:
+		i, err := indexBelow(ctx, c, key, page.After, true)
+		if err != nil {
+			return nil, Cursor{}, err
+		}
+		start, stop = i-size, i-1
+		if start < 0 {
+			start = 0
+		}
+	default:
+		start, stop = 0, size-1
+	}
+	if stop < start {
+		return []*Post{}, Cursor{}, nil
+	}
+
+	// fetch one more post to know if there is an older page
+	postIDs, err := c.LRange(ctx, key, start, stop+1).Result()
 	if err != nil {
-		return nil, err
+		return nil, Cursor{}, err
+	}
+	hasOlder := int64(len(postIDs)) > stop-start+1
+	if hasOlder {
+		postIDs = postIDs[:len(postIDs)-1]
 	}
 
 	posts := make([]*Post, len(postIDs))
 	for i, val := range postIDs {
 		id, err := strconv.ParseInt(val, 10, 64)
 		if err != nil {
-			return nil, err
+			return nil, Cursor{}, err
 		}
 		posts[i] = &Post{id: id, c: c}
 	}
-	return posts, nil
+
+	cursor := Cursor{}
+	if len(posts) > 0 {
+		if hasOlder {
+			cursor.Older = posts[len(posts)-1].id
+		}
+		if start > 0 {
+			cursor.Newer = posts[0].id
+		}
+	}
+	return posts, cursor, nil
 }
 
 // GetAllPosts All Updates getter
-func GetAllPosts(ctx context.Context, c redis.Cmdable) ([]*Post, error) {
-	return queryPosts(ctx, c, "posts")
+func GetAllPosts(ctx context.Context, c redis.Cmdable, page Page) ([]*Post, Cursor, error) {
+	return queryPosts(ctx, c, "posts", page)
 }
 
 // GetPosts gets all updates created by the user
-func GetPosts(ctx context.Context, c redis.Cmdable, userID int64) ([]*Post, error) {
+func GetPosts(ctx context.Context, c redis.Cmdable, userID int64, page Page) ([]*Post, Cursor, error) {
 	key := fmt.Sprintf("user:%d:posts", userID)
-	return queryPosts(ctx, c, key)
+	return queryPosts(ctx, c, key, page)
 }
 
+// MaxAttachments is how many media a post can have
+const MaxAttachments = 4
+
+// MaxAltLength is how many characters the alt text of a media can have
+const MaxAltLength = 1500
+
 // PostUpdate adds a new update; this differs from edit which actually changes
-func PostUpdate(ctx context.Context, c redis.Cmdable, userID int64, body, mediaID string) error {
+// attachments may be empty, there can be at most MaxAttachments of them
+func PostUpdate(ctx context.Context, c redis.Cmdable, userID int64, body string, attachments []pensive.Media) (*Post, error) {
 	if body == "" {
-		if mediaID == "" {
-			return ErrEmptyForm
+		if len(attachments) == 0 {
+			return nil, ErrEmptyForm
+		}
+	}
+	if len(attachments) > MaxAttachments {
+		return nil, ErrTooManyAttachments
+	}
+	for _, media := range attachments {
+		if utf8.RuneCountInString(media.Alt) > MaxAltLength {
+			return nil, ErrAltTooLong
 		}
 	}
 
 	p := pensive.Post{
-		User:    pensive.User{ID: userID},
-		Body:    body,
-		MediaID: mediaID,
+		User:        pensive.User{ID: userID},
+		Body:        body,
+		Attachments: attachments,
 	}
 
-	_, err := AddPost(ctx, c, p)
+	return AddPost(ctx, c, p)
+}
+
+// GetPost gets the post using a post id
+func GetPost(ctx context.Context, c redis.Cmdable, postID int64) (*Post, error) {
+	exists, err := c.Exists(ctx, fmt.Sprintf("post:%d", postID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if exists == 0 {
+		return nil, ErrPostNotFound
+	}
+	return &Post{id: postID, c: c}, nil
+}
+
+// ownedPost gets the post and makes sure that it is created by the user
+func ownedPost(ctx context.Context, c redis.Cmdable, userID, postID int64) (*Post, error) {
+	post, err := GetPost(ctx, c, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	ownerID, err := c.HGet(ctx, fmt.Sprintf("post:%d", postID), "user_id").Int64()
+	if err != nil {
+		return nil, err
+	}
+	if ownerID != userID {
+		return nil, ErrPerm
+	}
+	return post, nil
+}
+
+// EditPost changes the body of the post; this differs from update which adds a new post
+// userID is the current user requesting and must own the post
+func EditPost(ctx context.Context, c redis.Cmdable, userID, postID int64, body string) error {
+	post, err := ownedPost(ctx, c, userID, postID)
+	if err != nil {
+		return err
+	}
+
+	if body == "" {
+		attachments, err := post.Attachments(ctx)
+		if err != nil {
+			return err
+		}
+		if len(attachments) == 0 {
+			return ErrEmptyForm
+		}
+	}
+
+	// set updated at date
+	now := time.Now().UTC().String()
+
+	key := fmt.Sprintf("post:%d", postID)
+	pipe := c.Pipeline()
+	pipe.HSet(ctx, key, "body", body)
+	pipe.HSet(ctx, key, "updated_at", now)
+	_, err = pipe.Exec(ctx)
 	return err
 }
+
+// DeletePost removes the post and its references from the post lists
+// userID is the current user requesting and must own the post
+// returns the attachments of the removed post so their objects can also be removed
+func DeletePost(ctx context.Context, c redis.Cmdable, userID, postID int64) ([]pensive.Media, error) {
+	post, err := ownedPost(ctx, c, userID, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := post.Attachments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	followers, err := Followers(ctx, c, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := c.Pipeline()
+	pipe.Del(ctx, fmt.Sprintf("post:%d", postID))
+	pipe.LRem(ctx, "posts", 0, postID)
+	pipe.LRem(ctx, fmt.Sprintf("user:%d:posts", userID), 0, postID)
+	pipe.LRem(ctx, timelineKey(userID), 0, postID)
+	for _, follower := range followers {
+		pipe.LRem(ctx, timelineKey(follower), 0, postID)
+	}
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Quota limits the storage of a user, a zero field is unlimited
+type Quota struct {
+	Bytes   int64
+	Objects int64
+}
+
+// Usage is the storage taken by the blobs of a user, the resized copies of the pictures included
+type Usage struct {
+	Bytes   int64
+	Objects int64
+}
+
+func userStorageKey(userID int64) string { return fmt.Sprintf("user:%d:storage", userID) }
+func userQuotaKey(userID int64) string   { return fmt.Sprintf("user:%d:quota", userID) }
+
+// GetUsage gets the storage taken by the user
+func GetUsage(ctx context.Context, c redis.Cmdable, userID int64) (Usage, error) {
+	vals, err := c.HMGet(ctx, userStorageKey(userID), "bytes", "objects").Result()
+	if err != nil {
+		return Usage{}, err
+	}
+	return Usage{Bytes: hashInt(vals[0]), Objects: hashInt(vals[1])}, nil
+}
+
+// AddUsage counts the blobs saved for the user, negative values count the removed ones
+func AddUsage(ctx context.Context, c redis.Cmdable, userID, bytes, objects int64) error {
+	pipe := c.TxPipeline()
+	pipe.HIncrBy(ctx, userStorageKey(userID), "bytes", bytes)
+	pipe.HIncrBy(ctx, userStorageKey(userID), "objects", objects)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetUsage replaces the storage usage of the user, used when it is counted again from the blobs
+func SetUsage(ctx context.Context, c redis.Cmdable, userID int64, u Usage) error {
+	return c.HSet(ctx, userStorageKey(userID), "bytes", u.Bytes, "objects", u.Objects).Err()
+}
+
+// GetQuota gets the quota of the user, the fields the user has no quota of fallback to the default
+func GetQuota(ctx context.Context, c redis.Cmdable, userID int64, def Quota) (Quota, error) {
+	vals, err := c.HMGet(ctx, userQuotaKey(userID), "bytes", "objects").Result()
+	if err != nil {
+		return Quota{}, err
+	}
+	q := def
+	if vals[0] != nil {
+		q.Bytes = hashInt(vals[0])
+	}
+	if vals[1] != nil {
+		q.Objects = hashInt(vals[1])
+	}
+	return q, nil
+}
+
+// SetQuota overrides the default quota of the user, a zero field is unlimited
+func SetQuota(ctx context.Context, c redis.Cmdable, userID int64, q Quota) error {
+	return c.HSet(ctx, userQuotaKey(userID), "bytes", q.Bytes, "objects", q.Objects).Err()
+}
+
+// ResetQuota makes the user fallback to the default quota
+func ResetQuota(ctx context.Context, c redis.Cmdable, userID int64) error {
+	return c.Del(ctx, userQuotaKey(userID)).Err()
+}
+
+// CheckQuota tells if the user has room for objects more blobs of bytes in total
+// returns ErrQuotaExceeded with how much of the quota is used otherwise
+// it only answers early, the room is taken with ReserveUsage
+func CheckQuota(ctx context.Context, c redis.Cmdable, userID int64, def Quota, bytes, objects int64) error {
+	q, err := GetQuota(ctx, c, userID, def)
+	if err != nil {
+		return err
+	}
+	u, err := GetUsage(ctx, c, userID)
+	if err != nil {
+		return err
+	}
+	return quotaErr(q, u, bytes, objects)
+}
+
+// reserveUsage adds to the usage and takes it back when it goes past the quota, 0 means unlimited
+var reserveUsage = redis.NewScript(`
+local bytes = redis.call('HINCRBY', KEYS[1], 'bytes', ARGV[1])
+local objects = redis.call('HINCRBY', KEYS[1], 'objects', ARGV[2])
+local maxBytes, maxObjects = tonumber(ARGV[3]), tonumber(ARGV[4])
+if (maxBytes > 0 and bytes > maxBytes) or (maxObjects > 0 and objects > maxObjects) then
+	redis.call('HINCRBY', KEYS[1], 'bytes', ARGV[5])
+	redis.call('HINCRBY', KEYS[1], 'objects', ARGV[6])
+	return 0
+end
+return 1
+`)
+
+// ReserveUsage takes room for objects more blobs of bytes in total from the quota of the user
+// the check and the update are atomic so concurrent uploads cannot both take the last room
+// returns ErrQuotaExceeded with how much of the quota is used when there is not enough room
+func ReserveUsage(ctx context.Context, c redis.Cmdable, userID int64, def Quota, bytes, objects int64) error {
+	q, err := GetQuota(ctx, c, userID, def)
+	if err != nil {
+		return err
+	}
+	ok, err := reserveUsage.Run(ctx, c, []string{userStorageKey(userID)}, bytes, objects, q.Bytes, q.Objects, -bytes, -objects).Bool()
+	if err != nil || ok {
+		return err
+	}
+	u, err := GetUsage(ctx, c, userID)
+	if err != nil {
+		return err
+	}
+	if err := quotaErr(q, u, bytes, objects); err != nil {
+		return err
+	}
+	// the room was freed meanwhile
+	return fmt.Errorf("%w: try again", ErrQuotaExceeded)
+}
+
+// quotaErr tells if the usage has room for objects more blobs of bytes in total
+func quotaErr(q Quota, u Usage, bytes, objects int64) error {
+	if q.Bytes > 0 && u.Bytes+bytes > q.Bytes {
+		return fmt.Errorf("%w: %s of %s used, the upload needs %s", ErrQuotaExceeded, FormatBytes(u.Bytes), FormatBytes(q.Bytes), FormatBytes(bytes))
+	}
+	if q.Objects > 0 && u.Objects+objects > q.Objects {
+		return fmt.Errorf("%w: %d of %d files used", ErrQuotaExceeded, u.Objects, q.Objects)
+	}
+	return nil
+}
+
+// FormatBytes writes the size in the largest unit it has at least one of, e.g. "1.5 MiB"
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// hashInt reads an integer field of HMGet, a missing field is zero
+func hashInt(v interface{}) int64 {
+	s, _ := v.(string)
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
@@ -0,0 +1,51 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestReserveUsage(t *testing.T) {
+	ctx := context.Background()
+	c := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	def := Quota{Bytes: 100, Objects: 3}
+
+	tests := []struct {
+		name           string
+		bytes, objects int64
+		wantErr        error
+		want           Usage
+	}{
+		{name: "room", bytes: 60, objects: 1, want: Usage{Bytes: 60, Objects: 1}},
+		{name: "too many bytes", bytes: 41, objects: 1, wantErr: ErrQuotaExceeded, want: Usage{Bytes: 60, Objects: 1}},
+		{name: "last bytes", bytes: 40, objects: 1, want: Usage{Bytes: 100, Objects: 2}},
+		{name: "too many files", bytes: 0, objects: 2, wantErr: ErrQuotaExceeded, want: Usage{Bytes: 100, Objects: 2}},
+		{name: "released", bytes: -50, objects: -1, want: Usage{Bytes: 50, Objects: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ReserveUsage(ctx, c, 1, def, tt.bytes, tt.objects); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReserveUsage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got, err := GetUsage(ctx, c, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("GetUsage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// a quota of its own overrides the default, zero is unlimited
+	if err := SetQuota(ctx, c, 1, Quota{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ReserveUsage(ctx, c, 1, def, 1<<40, 100); err != nil {
+		t.Errorf("ReserveUsage() unlimited error = %v", err)
+	}
+}
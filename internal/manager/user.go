@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/gocs/pensive"
@@ -41,6 +43,17 @@ func (u *User) Email(ctx context.Context) (string, error) {
 	return u.c.HGet(ctx, key, "email").Result()
 }
 
+// PendingEmail getter
+// this is the new email waiting to be confirmed, empty if there is none
+func (u *User) PendingEmail(ctx context.Context) (string, error) {
+	key := fmt.Sprintf("user:%d", u.id)
+	email, err := u.c.HGet(ctx, key, "pending_email").Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	return email, err
+}
+
 // IsVerified getter
 func (u *User) IsVerified(ctx context.Context) (bool, error) {
 	key := fmt.Sprintf("user:%d", u.id)
@@ -51,6 +64,17 @@ func (u *User) IsVerified(ctx context.Context) (bool, error) {
 	return result == "true", nil
 }
 
+// RequiresAlt getter, whether the user wants to be stopped from posting pictures without alt text
+// users who never set it do not require it
+func (u *User) RequiresAlt(ctx context.Context) (bool, error) {
+	key := fmt.Sprintf("user:%d", u.id)
+	result, err := u.c.HGet(ctx, key, "require_alt").Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	return result == "true", err
+}
+
 // CreatedAt getter
 // there should be no setter for created at
 func (u *User) CreatedAt(ctx context.Context) (*time.Time, error) {
@@ -112,6 +136,18 @@ func (u *User) Verify(ctx context.Context, value bool) error {
 	return u.c.HSet(ctx, key, "is_verified", result).Err()
 }
 
+// RequireAlt RequiresAlt setter
+// this converts bool to a redis friendly string bool
+func (u *User) RequireAlt(ctx context.Context, value bool) error {
+	result := "false"
+	if value {
+		result = "true"
+	}
+
+	key := fmt.Sprintf("user:%d", u.id)
+	return u.c.HSet(ctx, key, "require_alt", result).Err()
+}
+
 // UpdateNow UpdatedAt setter
 func (u *User) UpdateNow(ctx context.Context) error {
 	now := time.Now().UTC().String()
@@ -168,7 +204,18 @@ func RegisterUser(ctx context.Context, c redis.Cmdable, username, password, emai
 }
 
 // AuthSelf checks if username has registered in this site
+// a personal api token in the "Authorization: Bearer" header is accepted in place of the session cookie
+// the token must have the read scope for safe methods, otherwise the write scope
 func AuthSelf(r *http.Request, s *sessions.Session, c redis.Cmdable, key string) (*User, error) {
+	if raw, ok := bearerToken(r); ok {
+		return AuthAPIToken(r.Context(), c, raw, requiredScope(r.Method))
+	}
+	return AuthSession(r, s, c, key)
+}
+
+// AuthSession checks if username has registered in this site using only the session cookie
+// this is used by account management where api tokens are not accepted
+func AuthSession(r *http.Request, s *sessions.Session, c redis.Cmdable, key string) (*User, error) {
 	userID, err := s.GetInt64(r, key)
 	if err != nil {
 		return nil, err
@@ -201,6 +248,25 @@ func GetUserByName(ctx context.Context, c redis.Cmdable, username string) (*User
 	return &User{id: id, c: c}, nil
 }
 
+// GetUsers gets every registered user, ordered by id
+func GetUsers(ctx context.Context, c redis.Cmdable) ([]*User, error) {
+	ids, err := c.HVals(ctx, "user:by-username").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, 0, len(ids))
+	for _, val := range ids {
+		id, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &User{id: id, c: c})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].id < users[j].id })
+	return users, nil
+}
+
 func GetUser(ctx context.Context, c redis.Cmdable, user *User) (pensive.User, error) {
 	username, err := user.Username(ctx)
 	if err != nil {
@@ -365,22 +431,51 @@ func UpdatePassword(ctx context.Context, c redis.Cmdable, userID int64, oldPassw
 		return err
 	}
 
+	if newPassword == "" {
+		return ErrEmptyForm
+	}
+	return setPassword(ctx, c, userID, newPassword)
+}
+
+// HashPassword validates the new password and hashes it for ResetPassword
+func HashPassword(newPassword string) ([]byte, error) {
+	if newPassword == "" {
+		return nil, ErrEmptyForm
+	}
+	return pensive.ValidatePassword(newPassword)
+}
+
+// ResetPassword sets the hash of a new password from HashPassword without asking for the old one
+// only use this after the user proved their identity, e.g. through a password reset token
+func ResetPassword(ctx context.Context, c redis.Cmdable, userID int64, hash []byte) error {
+	return setPasswordHash(ctx, c, userID, hash)
+}
+
+// setPassword hashes and saves the new password
+func setPassword(ctx context.Context, c redis.Cmdable, userID int64, newPassword string) error {
+	hash, err := pensive.ValidatePassword(newPassword)
+	if err != nil {
+		return err
+	}
+	return setPasswordHash(ctx, c, userID, hash)
+}
+
+func setPasswordHash(ctx context.Context, c redis.Cmdable, userID int64, hash []byte) error {
 	// set updated at date
 	now := time.Now().UTC().String()
 
 	// update all details in the pipe
-	key := fmt.Sprintf("user:%d", user.id)
+	key := fmt.Sprintf("user:%d", userID)
 	pipe := c.Pipeline()
-	pipe.HSet(ctx, key, "id", user.id)
-	if newPassword != "" {
-		pipe.HSet(ctx, key, "password", newPassword)
-	}
+	pipe.HSet(ctx, key, "id", userID)
+	pipe.HSet(ctx, key, "password", hash)
 	pipe.HSet(ctx, key, "updated_at", now)
-	_, err = pipe.Exec(ctx)
+	_, err := pipe.Exec(ctx)
 	return err
 }
 
-// UpdateEmail authenticates the user by its username and password and updates it with new ones
+// UpdateEmail authenticates the user by its username and password and keeps the new email as pending
+// the email is only changed once the pending email is confirmed with ConfirmEmail
 // userID is the current user requesting
 func UpdateEmail(ctx context.Context, c redis.Cmdable, userID int64, newEmail, oldPassword string) error {
 	user := &User{id: userID, c: c}
@@ -393,6 +488,17 @@ func UpdateEmail(ctx context.Context, c redis.Cmdable, userID int64, newEmail, o
 		return err
 	}
 
+	if newEmail == "" {
+		return ErrEmptyForm
+	}
+	email, err := user.Email(ctx)
+	if err != nil {
+		return err
+	}
+	if newEmail == email {
+		return ErrSameEmail
+	}
+
 	// set updated at date
 	now := time.Now().UTC().String()
 
@@ -400,9 +506,32 @@ func UpdateEmail(ctx context.Context, c redis.Cmdable, userID int64, newEmail, o
 	key := fmt.Sprintf("user:%d", user.id)
 	pipe := c.Pipeline()
 	pipe.HSet(ctx, key, "id", user.id)
-	if newEmail != "" {
-		pipe.HSet(ctx, key, "email", newEmail)
+	pipe.HSet(ctx, key, "pending_email", newEmail)
+	pipe.HSet(ctx, key, "updated_at", now)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// ConfirmEmail replaces the email with the pending email, which is now verified
+// only use this after the user proved they own the pending email, e.g. through a token sent to it
+func ConfirmEmail(ctx context.Context, c redis.Cmdable, userID int64) error {
+	user := &User{id: userID, c: c}
+	pending, err := user.PendingEmail(ctx)
+	if err != nil {
+		return err
+	}
+	if pending == "" {
+		return ErrNoPendingEmail
 	}
+
+	// set updated at date
+	now := time.Now().UTC().String()
+
+	key := fmt.Sprintf("user:%d", user.id)
+	pipe := c.Pipeline()
+	pipe.HSet(ctx, key, "email", pending)
+	pipe.HSet(ctx, key, "is_verified", "true")
+	pipe.HDel(ctx, key, "pending_email")
 	pipe.HSet(ctx, key, "updated_at", now)
 	_, err = pipe.Exec(ctx)
 	return err
@@ -0,0 +1,137 @@
+package managerstore
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/pkg/blobstore"
+	"github.com/redis/go-redis/v9"
+)
+
+// CollectOptions tells CollectOrphans what to do with the blobs that no post refers to
+type CollectOptions struct {
+	// Grace keeps the blobs younger than it, a post is added only after its media are saved
+	// and a finished resumable upload is attached to a post at most UploadTTL after it started
+	Grace time.Duration
+	// Quarantine moves the orphans to this bucket under the same key instead of deleting them
+	// so they can be copied back if the collector was wrong
+	Quarantine string
+}
+
+// CollectStats counts what CollectOrphans did
+type CollectStats struct {
+	Scanned     int   // blobs in the bucket
+	Referenced  int   // blobs the posts refer to
+	Young       int   // orphans kept since they are younger than the grace period
+	Orphans     int   // orphans deleted or quarantined
+	OrphanBytes int64 // size of the orphans deleted or quarantined
+}
+
+// CollectOrphans removes the blobs of the bucket that no post refers to, e.g. the media of a post that failed to be added
+// the references are read after the bucket is listed, so a blob saved meanwhile is only listed the next time
+// only the given bucket is scanned, the legacy per-user buckets are not collected
+func CollectOrphans(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, opts CollectOptions) (CollectStats, error) {
+	stats := CollectStats{}
+	infos, err := objs.List(ctx, bucket, "")
+	if err != nil {
+		return stats, err
+	}
+	stats.Scanned = len(infos)
+
+	refs, err := referencedMedia(ctx, c)
+	if err != nil {
+		return stats, err
+	}
+
+	now := time.Now()
+	for _, info := range infos {
+		if refs[info.Key] {
+			stats.Referenced++
+			continue
+		}
+		if now.Sub(info.LastModified) < opts.Grace {
+			stats.Young++
+			continue
+		}
+
+		if opts.Quarantine != "" {
+			err = quarantineBlob(ctx, objs, c, bucket, opts.Quarantine, info)
+		} else {
+			err = removeOrphan(ctx, objs, c, bucket, info.Key)
+		}
+		if err != nil {
+			return stats, err
+		}
+		stats.Orphans++
+		stats.OrphanBytes += info.Size
+	}
+	return stats, nil
+}
+
+// referencedMedia gets the keys of the blobs of every post, the resized copies included
+func referencedMedia(ctx context.Context, c redis.Cmdable) (map[string]bool, error) {
+	refs := map[string]bool{}
+	page := manager.Page{Size: manager.MaxPageSize}
+	for {
+		posts, cursor, err := manager.GetAllPosts(ctx, c, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, post := range posts {
+			owner, err := post.User(ctx)
+			if err != nil {
+				return nil, err
+			}
+			// the legacy media_id of the oldest posts is read as an attachment too
+			attachments, err := post.Attachments(ctx)
+			if err != nil {
+				return nil, err
+			}
+			for _, media := range attachments {
+				refs[MediaKey(owner.ID(), media.ID)] = true
+				for _, v := range media.Variants {
+					refs[MediaKey(owner.ID(), v.ID)] = true
+				}
+			}
+		}
+		if cursor.Older == 0 {
+			return refs, nil
+		}
+		page.Before = cursor.Older
+	}
+}
+
+// quarantineBlob moves the orphan to the quarantine bucket
+func quarantineBlob(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket, quarantine string, info blobstore.Info) error {
+	// listings may not carry the content type and metadata
+	info, err := objs.Stat(ctx, bucket, info.Key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	r, err := objs.Get(ctx, bucket, info.Key, 0, -1)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := objs.Put(ctx, quarantine, info.Key, r, info.Size, blobstore.PutOptions{ContentType: info.ContentType, Metadata: info.Metadata}); err != nil {
+		return err
+	}
+	return removeOrphan(ctx, objs, c, bucket, info.Key)
+}
+
+// removeOrphan deletes the orphan, taking it out of the storage usage of its owner when the key has one
+func removeOrphan(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket, key string) error {
+	owner, id, _ := strings.Cut(key, "/")
+	userID, err := strconv.ParseInt(owner, 10, 64)
+	if err != nil || id == "" {
+		return objs.Delete(ctx, bucket, key)
+	}
+	return deleteBlob(ctx, objs, c, bucket, userID, id)
+}
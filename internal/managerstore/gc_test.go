@@ -0,0 +1,124 @@
+package managerstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gocs/pensive"
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/pkg/blobstore"
+	"github.com/gocs/pensive/pkg/file"
+	"github.com/redis/go-redis/v9"
+)
+
+var textInfo = file.MediaInfo{MIME: "text/plain", Category: "text", Extension: ".txt"}
+
+// newTestStore makes a blob store with a media bucket in a temporary directory and an in-memory redis
+func newTestStore(t *testing.T) (string, *blobstore.FS, redis.Cmdable) {
+	root := t.TempDir()
+	objs, err := blobstore.NewFS(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := objs.MakeBucket(context.Background(), "media"); err != nil {
+		t.Fatal(err)
+	}
+	c := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	return root, objs, c
+}
+
+// testPNG encodes a blank picture of the size
+func testPNG(t *testing.T, w, h int) []byte {
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// saveText saves a text media of the user
+func saveText(t *testing.T, objs blobstore.BlobStore, c redis.Cmdable, userID int64, body string) pensive.Media {
+	media, err := SaveMedia(context.Background(), objs, c, "media", userID, manager.Quota{}, strings.NewReader(body), "a.txt", int64(len(body)), textInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return media
+}
+
+func TestCollectOrphans(t *testing.T) {
+	for _, quarantine := range []string{"", "quarantine"} {
+		t.Run("quarantine="+quarantine, func(t *testing.T) {
+			ctx := context.Background()
+			root, objs, c := newTestStore(t)
+			if quarantine != "" {
+				if err := objs.MakeBucket(ctx, quarantine); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data := testPNG(t, 1600, 1200)
+			picture, err := SaveMedia(ctx, objs, c, "media", 1, manager.Quota{}, bytes.NewReader(data), "a.png", int64(len(data)), pngInfo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(picture.Variants) != 2 {
+				t.Fatalf("SaveMedia() variants = %+v, want thumb and feed", picture.Variants)
+			}
+			if _, err := manager.AddPost(ctx, c, pensive.Post{User: pensive.User{ID: 1}, Attachments: []pensive.Media{picture}}); err != nil {
+				t.Fatal(err)
+			}
+
+			young := saveText(t, objs, c, 1, "young")
+			old := saveText(t, objs, c, 1, "old orphan")
+			past := time.Now().Add(-72 * time.Hour)
+			if err := os.Chtimes(filepath.Join(root, "media", MediaKey(1, old.ID)), past, past); err != nil {
+				t.Fatal(err)
+			}
+
+			before, err := manager.GetUsage(ctx, c, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stats, err := CollectOrphans(ctx, objs, c, "media", CollectOptions{Grace: 48 * time.Hour, Quarantine: quarantine})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := CollectStats{Scanned: 5, Referenced: 3, Young: 1, Orphans: 1, OrphanBytes: old.Size}
+			if stats != want {
+				t.Errorf("CollectOrphans() = %+v, want %+v", stats, want)
+			}
+
+			kept := []string{picture.ID, picture.Variants[0].ID, picture.Variants[1].ID, young.ID}
+			for _, id := range kept {
+				if _, err := objs.Stat(ctx, "media", MediaKey(1, id)); err != nil {
+					t.Errorf("Stat(%s) error = %v, want it kept", id, err)
+				}
+			}
+			if _, err := objs.Stat(ctx, "media", MediaKey(1, old.ID)); !errors.Is(err, blobstore.ErrNotFound) {
+				t.Errorf("Stat(old) error = %v, want %v", err, blobstore.ErrNotFound)
+			}
+			if quarantine != "" {
+				if _, err := objs.Stat(ctx, quarantine, MediaKey(1, old.ID)); err != nil {
+					t.Errorf("Stat(quarantined) error = %v", err)
+				}
+			}
+
+			after, err := manager.GetUsage(ctx, c, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Bytes != before.Bytes-old.Size || after.Objects != before.Objects-1 {
+				t.Errorf("GetUsage() = %+v, want %+v less the orphan", after, before)
+			}
+		})
+	}
+}
@@ -1,103 +1,350 @@
 package managerstore
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/gocs/pensive"
 	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/pkg/blobstore"
 	"github.com/gocs/pensive/pkg/file"
-	"github.com/gocs/pensive/pkg/objectstore"
+	"github.com/gocs/pensive/pkg/imaging"
 	"github.com/redis/go-redis/v9"
 )
 
-func ListPost(ctx context.Context, objs *objectstore.ObjectStore, c redis.Cmdable) ([]pensive.PostPublic, error) {
-	posts, err := manager.GetAllPosts(ctx, c)
+// ListPost lists a page of posts from everyone
+func ListPost(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, page manager.Page) ([]pensive.PostPublic, manager.Cursor, error) {
+	posts, cursor, err := manager.GetAllPosts(ctx, c, page)
 	if err != nil {
-		return nil, err
+		return nil, manager.Cursor{}, err
 	}
 
-	ps := []pensive.PostPublic{}
-	for _, post := range posts {
-		user, err := post.User(ctx)
-		if err != nil {
-			return nil, err
-		}
-		username, err := user.Username(ctx)
-		if err != nil {
-			return nil, err
-		}
-		body, err := post.Body(ctx)
-		if err != nil {
-			return nil, err
-		}
-		filename, err := post.MediaID(ctx)
-		if err != nil {
-			return nil, err
-		}
-		updatedAt, err := post.UpdatedAt(ctx)
-		if err != nil {
-			return nil, err
+	ps, err := publicPosts(ctx, posts)
+	if err != nil {
+		return nil, manager.Cursor{}, err
+	}
+	return ps, cursor, nil
+}
+
+// ListPostByUserID lists a page of posts created by the user
+func ListPostByUserID(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, userID int64, page manager.Page) ([]pensive.PostPublic, manager.Cursor, error) {
+	posts, cursor, err := manager.GetPosts(ctx, c, userID, page)
+	if err != nil {
+		return nil, manager.Cursor{}, err
+	}
+
+	ps, err := publicPosts(ctx, posts)
+	if err != nil {
+		return nil, manager.Cursor{}, err
+	}
+	return ps, cursor, nil
+}
+
+// ListTimeline lists a page of posts from the user and the users they follow
+func ListTimeline(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, userID int64, page manager.Page) ([]pensive.PostPublic, manager.Cursor, error) {
+	posts, cursor, err := manager.GetTimeline(ctx, c, userID, page)
+	if err != nil {
+		return nil, manager.Cursor{}, err
+	}
+
+	ps, err := publicPosts(ctx, posts)
+	if err != nil {
+		return nil, manager.Cursor{}, err
+	}
+	return ps, cursor, nil
+}
+
+// GetPost gets a post created by the user
+// returns manager.ErrPostNotFound if the post does not belong to the user
+func GetPost(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, userID, postID int64) (pensive.PostPublic, error) {
+	post, err := manager.GetPost(ctx, c, postID)
+	if err != nil {
+		return pensive.PostPublic{}, err
+	}
+
+	owner, err := post.User(ctx)
+	if err != nil {
+		return pensive.PostPublic{}, err
+	}
+	if owner.ID() != userID {
+		return pensive.PostPublic{}, manager.ErrPostNotFound
+	}
+	return publicPost(ctx, post)
+}
+
+// DeletePost removes the post created by the user together with its attachments
+func DeletePost(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, userID, postID int64) error {
+	attachments, err := manager.DeletePost(ctx, c, userID, postID)
+	if err != nil {
+		return err
+	}
+	for _, media := range attachments {
+		if err := RemoveMedia(ctx, objs, c, bucket, userID, media); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		attachmentURL := ""
-		if filename != "" {
-			attachmentURL = fmt.Sprintf("/@%s/%s", username, filename)
+// MediaKey is the key of a media of the user in the shared media bucket
+// keys start with the user ID rather than the username so they survive renames
+func MediaKey(userID int64, id string) string {
+	return fmt.Sprintf("%d/%s", userID, id)
+}
+
+// RemoveMedia removes the blobs of the media and its variants
+func RemoveMedia(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, userID int64, media pensive.Media) error {
+	for _, v := range media.Variants {
+		if err := deleteBlob(ctx, objs, c, bucket, userID, v.ID); err != nil {
+			return err
 		}
+	}
+	return deleteBlob(ctx, objs, c, bucket, userID, media.ID)
+}
 
-		ps = append(ps, pensive.PostPublic{
-			User:           username,
-			Caption:        body,
-			AttachmentURL:  attachmentURL,
-			AttachmentType: file.GetMediaType(filename),
-			UpdatedAt:      updatedAt.Format(time.RFC822),
-		})
+// CountUsage counts the blobs of the user in the bucket again and replaces their storage usage with it
+// the media saved before the usage was tracked are only counted this way
+func CountUsage(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, userID int64) (manager.Usage, error) {
+	infos, err := objs.List(ctx, bucket, MediaKey(userID, ""))
+	if err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+		return manager.Usage{}, err
 	}
-	return ps, nil
+	u := manager.Usage{Objects: int64(len(infos))}
+	for _, info := range infos {
+		u.Bytes += info.Size
+	}
+	return u, manager.SetUsage(ctx, c, userID, u)
 }
 
-func ListPostByUserID(ctx context.Context, objs *objectstore.ObjectStore, c redis.Cmdable, userID int64) ([]pensive.PostPublic, error) {
-	posts, err := manager.GetPosts(ctx, c, userID)
+// putBlob saves a blob of the user and returns its size, the caller counts it in their storage usage
+func putBlob(ctx context.Context, objs blobstore.BlobStore, bucket string, userID int64, id string, r io.Reader, size int64, opts blobstore.PutOptions) (int64, error) {
+	info, err := objs.Put(ctx, bucket, MediaKey(userID, id), r, size, opts)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	return info.Size, nil
+}
 
-	ps := []pensive.PostPublic{}
-	for _, post := range posts {
-		user, err := post.User(ctx)
-		if err != nil {
-			return nil, err
+func removeBlobKey(bucket, key string) string { return fmt.Sprintf("blob:%s:%s:removing", bucket, key) }
+
+// deleteBlob removes a blob of the user and its size from their storage usage
+// the size is looked up since the media only records the size of the original
+// a blob removed by two callers at once, e.g. DeletePost and the collector, is only taken out of the usage by one of them
+func deleteBlob(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, userID int64, id string) error {
+	key := MediaKey(userID, id)
+	// the other caller removes it, once it is done the blob is no longer found
+	removing, err := c.SetNX(ctx, removeBlobKey(bucket, key), 1, time.Minute).Result()
+	if err != nil || !removing {
+		return err
+	}
+	defer c.Del(context.WithoutCancel(ctx), removeBlobKey(bucket, key))
+
+	info, err := objs.Stat(ctx, bucket, key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := objs.Delete(ctx, bucket, key); err != nil {
+		return err
+	}
+	return manager.AddUsage(ctx, c, userID, -info.Size, -1)
+}
+
+// SaveMedia saves the upload of the user in the bucket under a generated name with the extension of its sniffed type
+// the original filename is kept in the returned media and as blob metadata for downloads
+// returns manager.ErrQuotaExceeded when the quota of the user has no room for the media and its resized copies
+func SaveMedia(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, userID int64, quota manager.Quota, r io.Reader, filename string, size int64, info file.MediaInfo) (pensive.Media, error) {
+	name := cleanFilename(filename)
+
+	// the room is reserved before anything is saved so concurrent uploads cannot go past the quota
+	// each resized copy of a picture is expected to be at most as large as the original
+	reserved := manager.Usage{Bytes: size, Objects: 1}
+	if processable(info.MIME) {
+		n := int64(len(imaging.DefaultVariants))
+		reserved = manager.Usage{Bytes: size * (1 + n), Objects: 1 + n}
+	}
+	if err := manager.ReserveUsage(ctx, c, userID, quota, reserved.Bytes, reserved.Objects); err != nil {
+		return pensive.Media{}, err
+	}
+	// the reservation is replaced by what was saved, the blobs removed on failure take their own size back
+	saved := manager.Usage{}
+	defer func() {
+		if err := manager.AddUsage(context.WithoutCancel(ctx), c, userID, saved.Bytes-reserved.Bytes, saved.Objects-reserved.Objects); err != nil {
+			log.Println("AddUsage err:", err)
 		}
-		username, err := user.Username(ctx)
-		if err != nil {
-			return nil, err
+	}()
+
+	id, err := randomID()
+	if err != nil {
+		return pensive.Media{}, err
+	}
+	media := pensive.Media{ID: id + info.Extension, Type: info.MIME, Name: name, Size: size}
+
+	opts := blobstore.PutOptions{
+		ContentType: info.MIME,
+		// escaped since metadata travels as http headers which only carry ascii safely
+		Metadata: map[string]string{"filename": url.PathEscape(name)},
+	}
+
+	if !processable(info.MIME) {
+		// the pictures that cannot be resized, e.g. webp, are still stored without their metadata
+		if info.Category == "image" {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return pensive.Media{}, err
+			}
+			stripped, err := imaging.StripMetadata(info.MIME, data)
+			if err != nil {
+				return pensive.Media{}, err
+			}
+			r, size = bytes.NewReader(stripped), int64(len(stripped))
+			media.Size = size
 		}
-		body, err := post.Body(ctx)
+		n, err := putBlob(ctx, objs, bucket, userID, media.ID, r, size, opts)
 		if err != nil {
-			return nil, err
+			return pensive.Media{}, err
 		}
-		filename, err := post.MediaID(ctx)
+		saved = manager.Usage{Bytes: n, Objects: 1}
+		return media, nil
+	}
+
+	// pictures are small enough to be processed in memory, their size is limited by file.Policy
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return pensive.Media{}, err
+	}
+	res, err := imaging.Process(info.MIME, data, imaging.DefaultVariants)
+	if err != nil {
+		return pensive.Media{}, err
+	}
+
+	// the original is stored without its metadata
+	media.Size, media.Width, media.Height = int64(len(res.Original)), res.Width, res.Height
+	n, err := putBlob(ctx, objs, bucket, userID, media.ID, bytes.NewReader(res.Original), media.Size, opts)
+	if err != nil {
+		return pensive.Media{}, err
+	}
+	saved = manager.Usage{Bytes: n, Objects: 1}
+
+	for _, d := range res.Derivatives {
+		v := pensive.MediaVariant{Name: d.Name, ID: id + "_" + d.Name + d.Extension, Width: d.Width, Height: d.Height}
+		vopts := blobstore.PutOptions{ContentType: d.MIME, Metadata: opts.Metadata}
+		n, err := putBlob(ctx, objs, bucket, userID, v.ID, bytes.NewReader(d.Data), int64(len(d.Data)), vopts)
 		if err != nil {
-			return nil, err
+			// do not leave the blobs saved so far behind
+			if rerr := RemoveMedia(ctx, objs, c, bucket, userID, media); rerr != nil {
+				log.Println("RemoveMedia err:", rerr)
+			}
+			return pensive.Media{}, err
 		}
-		updatedAt, err := post.UpdatedAt(ctx)
+		saved.Bytes, saved.Objects = saved.Bytes+n, saved.Objects+1
+		media.Variants = append(media.Variants, v)
+	}
+	return media, nil
+}
+
+// randomID makes the unguessable names of the media and the uploads
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// processable tells if the media is a picture that imaging can resize
+func processable(mime string) bool {
+	switch mime {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// cleanFilename drops the directories a client may send with the filename
+func cleanFilename(filename string) string {
+	name := path.Base(strings.ReplaceAll(filename, "\\", "/"))
+	if name == "." || name == "/" || name == ".." {
+		return "media"
+	}
+	return name
+}
+
+func publicPosts(ctx context.Context, posts []*manager.Post) ([]pensive.PostPublic, error) {
+	ps := []pensive.PostPublic{}
+	for _, post := range posts {
+		p, err := publicPost(ctx, post)
 		if err != nil {
 			return nil, err
 		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
 
-		attachmentURL := "#"
-		if filename != "" {
-			attachmentURL = fmt.Sprintf("/@%s/%s", username, filename)
-		}
+func publicPost(ctx context.Context, post *manager.Post) (pensive.PostPublic, error) {
+	user, err := post.User(ctx)
+	if err != nil {
+		return pensive.PostPublic{}, err
+	}
+	username, err := user.Username(ctx)
+	if err != nil {
+		return pensive.PostPublic{}, err
+	}
+	body, err := post.Body(ctx)
+	if err != nil {
+		return pensive.PostPublic{}, err
+	}
+	attachments, err := post.Attachments(ctx)
+	if err != nil {
+		return pensive.PostPublic{}, err
+	}
+	updatedAt, err := post.UpdatedAt(ctx)
+	if err != nil {
+		return pensive.PostPublic{}, err
+	}
 
-		ps = append(ps, pensive.PostPublic{
-			User:           username,
-			Caption:        body,
-			AttachmentURL:  attachmentURL,
-			AttachmentType: file.GetMediaType(filename),
-			UpdatedAt:      updatedAt.Format(time.RFC822),
+	as := []pensive.AttachmentPublic{}
+	for _, media := range attachments {
+		as = append(as, pensive.AttachmentPublic{
+			URL:    fmt.Sprintf("/@%s/%s", username, media.ID),
+			Type:   file.GetMediaType(media.ID),
+			Name:   media.Name,
+			Size:   media.Size,
+			Alt:    media.Alt,
+			Srcset: srcset(username, media),
 		})
 	}
-	return ps, nil
+
+	return pensive.PostPublic{
+		ID:          post.ID(),
+		User:        username,
+		Caption:     body,
+		Attachments: as,
+		UpdatedAt:   updatedAt.Format(time.RFC822),
+	}, nil
+}
+
+// srcset lists the resized copies and the original of a picture by their width
+func srcset(username string, media pensive.Media) string {
+	if len(media.Variants) == 0 {
+		return ""
+	}
+	set := []string{}
+	for _, v := range media.Variants {
+		set = append(set, fmt.Sprintf("/@%s/%s %dw", username, v.ID, v.Width))
+	}
+	set = append(set, fmt.Sprintf("/@%s/%s %dw", username, media.ID, media.Width))
+	return strings.Join(set, ", ")
 }
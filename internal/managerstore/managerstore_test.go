@@ -0,0 +1,125 @@
+package managerstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/pkg/blobstore"
+	"github.com/gocs/pensive/pkg/file"
+	"github.com/redis/go-redis/v9"
+)
+
+var pngInfo = file.MediaInfo{MIME: "image/png", Category: "image", Extension: ".png"}
+
+// failingStore fails to put the blobs whose key contains fail
+type failingStore struct {
+	blobstore.BlobStore
+	fail string
+}
+
+func (s failingStore) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, opts blobstore.PutOptions) (blobstore.Info, error) {
+	if strings.Contains(key, s.fail) {
+		return blobstore.Info{}, errors.New("put failed")
+	}
+	return s.BlobStore.Put(ctx, bucket, key, r, size, opts)
+}
+
+// storedUsage counts the blobs of the user in the media bucket
+func storedUsage(t *testing.T, objs blobstore.BlobStore, userID int64) manager.Usage {
+	infos, err := objs.List(context.Background(), "media", MediaKey(userID, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := manager.Usage{Objects: int64(len(infos))}
+	for _, info := range infos {
+		u.Bytes += info.Size
+	}
+	return u
+}
+
+func getUsage(t *testing.T, c redis.Cmdable, userID int64) manager.Usage {
+	u, err := manager.GetUsage(context.Background(), c, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestSaveMediaUsage(t *testing.T) {
+	ctx := context.Background()
+	data := testPNG(t, 1600, 1200)
+	size := int64(len(data))
+
+	t.Run("settled to the saved blobs", func(t *testing.T) {
+		_, objs, c := newTestStore(t)
+		if _, err := SaveMedia(ctx, objs, c, "media", 1, manager.Quota{}, bytes.NewReader(data), "a.png", size, pngInfo); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := getUsage(t, c, 1), storedUsage(t, objs, 1); got != want {
+			t.Errorf("GetUsage() = %+v, want %+v", got, want)
+		}
+	})
+
+	t.Run("no room for the resized copies", func(t *testing.T) {
+		_, objs, c := newTestStore(t)
+		// the original fits but not the copies expected with it
+		quota := manager.Quota{Bytes: 2 * size}
+		_, err := SaveMedia(ctx, objs, c, "media", 1, quota, bytes.NewReader(data), "a.png", size, pngInfo)
+		if !errors.Is(err, manager.ErrQuotaExceeded) {
+			t.Fatalf("SaveMedia() error = %v, want %v", err, manager.ErrQuotaExceeded)
+		}
+		if got := getUsage(t, c, 1); got != (manager.Usage{}) {
+			t.Errorf("GetUsage() = %+v, want nothing reserved", got)
+		}
+		if got := storedUsage(t, objs, 1); got.Objects != 0 {
+			t.Errorf("%d blobs saved, want none", got.Objects)
+		}
+	})
+
+	t.Run("resized copy fails", func(t *testing.T) {
+		_, fs, c := newTestStore(t)
+		objs := failingStore{BlobStore: fs, fail: "_feed"}
+		if _, err := SaveMedia(ctx, objs, c, "media", 1, manager.Quota{}, bytes.NewReader(data), "a.png", size, pngInfo); err == nil {
+			t.Fatal("SaveMedia() error = nil, want the put error")
+		}
+		// the blobs saved before the failure are removed and the reservation is released
+		if got := storedUsage(t, objs, 1); got.Objects != 0 {
+			t.Errorf("%d blobs left, want none", got.Objects)
+		}
+		if got := getUsage(t, c, 1); got != (manager.Usage{}) {
+			t.Errorf("GetUsage() = %+v, want nothing used", got)
+		}
+	})
+}
+
+func TestDeleteBlobUsage(t *testing.T) {
+	ctx := context.Background()
+	_, objs, c := newTestStore(t)
+	a := saveText(t, objs, c, 1, "removed twice")
+	b := saveText(t, objs, c, 1, "removed by another")
+
+	for i := 0; i < 2; i++ {
+		if err := deleteBlob(ctx, objs, c, "media", 1, a.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := getUsage(t, c, 1), storedUsage(t, objs, 1); got != want {
+		t.Errorf("GetUsage() after removing twice = %+v, want %+v", got, want)
+	}
+
+	// another caller is removing the blob, it takes it out of the usage
+	if err := c.Set(ctx, removeBlobKey("media", MediaKey(1, b.ID)), 1, 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteBlob(ctx, objs, c, "media", 1, b.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := getUsage(t, c, 1); got != (manager.Usage{Bytes: b.Size, Objects: 1}) {
+		t.Errorf("GetUsage() = %+v, want the blob still counted", got)
+	}
+}
@@ -0,0 +1,138 @@
+package managerstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/pkg/blobstore"
+	"github.com/redis/go-redis/v9"
+)
+
+// MigrateStats counts what MigrateMedia did, or would do on a dry run
+type MigrateStats struct {
+	Copied  int // blobs copied to the shared bucket
+	Skipped int // blobs already in the shared bucket
+	Posts   int // posts whose attachments were rewritten
+	Missing int // blobs of the attachments found in no bucket
+}
+
+// MigrateMedia copies the blobs of the buckets named after the user into the shared bucket under MediaKey
+// then rewrites the attachments of their posts so the oldest ones use the attachments field too
+// formerNames are the usernames the user had before, a user renamed before the migration has their media
+// in the bucket of the username they had when posting, which nothing records
+// the attachments whose blobs are in none of the buckets are logged and counted as missing
+// the blobs already copied are skipped so it can be run again after a failure; the old buckets are left as is
+func MigrateMedia(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, user *manager.User, formerNames []string, dryRun bool) (MigrateStats, error) {
+	stats := MigrateStats{}
+	username, err := user.Username(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	// the keys in the old buckets, on a dry run they are not in the shared bucket yet
+	found := map[string]bool{}
+	for _, from := range append([]string{username}, formerNames...) {
+		infos, err := objs.List(ctx, from, "")
+		if err != nil && !errors.Is(err, blobstore.ErrNotFound) {
+			return stats, err
+		}
+		for _, info := range infos {
+			copied, err := copyMedia(ctx, objs, c, from, info.Key, bucket, user.ID(), dryRun)
+			if err != nil {
+				return stats, fmt.Errorf("%s/%s: %w", from, info.Key, err)
+			}
+			if copied {
+				stats.Copied++
+			} else {
+				stats.Skipped++
+			}
+			found[info.Key] = true
+		}
+	}
+
+	page := manager.Page{Size: manager.MaxPageSize}
+	for {
+		posts, cursor, err := manager.GetPosts(ctx, c, user.ID(), page)
+		if err != nil {
+			return stats, err
+		}
+		for _, post := range posts {
+			attachments, err := post.Attachments(ctx)
+			if err != nil {
+				return stats, err
+			}
+			for _, media := range attachments {
+				ids := []string{media.ID}
+				for _, v := range media.Variants {
+					ids = append(ids, v.ID)
+				}
+				for _, id := range ids {
+					if found[id] {
+						continue
+					}
+					if _, err := objs.Stat(ctx, bucket, MediaKey(user.ID(), id)); errors.Is(err, blobstore.ErrNotFound) {
+						log.Printf("@%s (%d): post %d: %s is in none of the buckets", username, user.ID(), post.ID(), id)
+						stats.Missing++
+					} else if err != nil {
+						return stats, err
+					}
+				}
+			}
+			if !dryRun {
+				if err := post.SetAttachments(ctx, attachments); err != nil {
+					return stats, err
+				}
+			}
+			stats.Posts++
+		}
+		if cursor.Older == 0 {
+			return stats, nil
+		}
+		page.Before = cursor.Older
+	}
+}
+
+// copyMedia copies the blob to the media of the user unless it already exists there, it reports whether it copied
+// the copies count in the storage usage of the user
+func copyMedia(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, fromBucket, fromKey, toBucket string, userID int64, dryRun bool) (bool, error) {
+	toKey := MediaKey(userID, fromKey)
+	if _, err := objs.Stat(ctx, toBucket, toKey); err == nil {
+		return false, nil
+	} else if !errors.Is(err, blobstore.ErrNotFound) {
+		return false, err
+	}
+	if dryRun {
+		return true, nil
+	}
+
+	// listings may not carry the content type and metadata
+	info, err := objs.Stat(ctx, fromBucket, fromKey)
+	if err != nil {
+		return false, err
+	}
+	meta := map[string]string{}
+	for k, v := range info.Metadata {
+		meta[k] = v
+	}
+	if meta["filename"] == "" {
+		// the oldest blobs were stored under their original filename
+		meta["filename"] = url.PathEscape(fromKey)
+	}
+
+	r, err := objs.Get(ctx, fromBucket, fromKey, 0, -1)
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	n, err := putBlob(ctx, objs, toBucket, userID, fromKey, r, info.Size, blobstore.PutOptions{ContentType: info.ContentType, Metadata: meta})
+	if err != nil {
+		return false, err
+	}
+	// the media kept before the quota are counted but not held to it
+	return true, manager.AddUsage(ctx, c, userID, n, 1)
+}
@@ -0,0 +1,341 @@
+package managerstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gocs/pensive"
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/pkg/blobstore"
+	"github.com/gocs/pensive/pkg/file"
+	"github.com/redis/go-redis/v9"
+)
+
+// UploadTTL is how long an unfinished upload can be resumed
+// the parts of the expired uploads stay in the blob store until it drops its incomplete uploads
+const UploadTTL = 24 * time.Hour
+
+// uploadPartSize is the size of the parts sent to the blob store
+// the chunks of the client are buffered into parts since they may be smaller than what the store accepts
+const uploadPartSize = blobstore.MinPartSize
+
+// uploadLockTTL bounds how long a writer that died keeps the upload locked
+const uploadLockTTL = 5 * time.Minute
+
+// Upload is a resumable upload of a media, received in chunks and assembled as a multipart upload
+type Upload struct {
+	ID       string `json:"id"`
+	UserID   int64  `json:"user_id"`
+	Filename string `json:"filename"`
+	Length   int64  `json:"length"`
+	// Offset is how many bytes were received, the ones past the parts are kept until they fill a part
+	Offset int64 `json:"offset"`
+	// MediaID and MIME are set from the type sniffed when the first part is sent
+	MediaID     string           `json:"media_id,omitempty"`
+	MIME        string           `json:"mime,omitempty"`
+	MultipartID string           `json:"multipart_id,omitempty"`
+	Parts       []blobstore.Part `json:"parts,omitempty"`
+	Complete    bool             `json:"complete"`
+	ExpiresAt   time.Time        `json:"expires_at"`
+}
+
+// unlockUpload and extendUploadLock only touch the lock while it holds the token of the writer
+// a lock that expired and was taken by another writer is left to it
+var (
+	unlockUpload = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+	extendUploadLock = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+func uploadKey(id string) string     { return fmt.Sprintf("upload:%s", id) }
+func uploadTailKey(id string) string { return fmt.Sprintf("upload:%s:tail", id) }
+func uploadLockKey(id string) string { return fmt.Sprintf("upload:%s:lock", id) }
+
+// CreateUpload starts a resumable upload of length bytes for the user
+// the type is only known from the first part so the size is checked against the largest limit of the policy
+// the quota is checked here to refuse early, the room is reserved when the upload is assembled
+func CreateUpload(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, policy file.Policy, quota manager.Quota, userID int64, filename string, length int64) (Upload, error) {
+	if _, ok := objs.(blobstore.Multipart); !ok {
+		return Upload{}, manager.ErrUploadUnsupported
+	}
+	if length <= 0 {
+		return Upload{}, manager.ErrEmptyForm
+	}
+	limit := int64(0)
+	for _, size := range policy.MaxSize {
+		limit = max(limit, size)
+	}
+	if length > limit {
+		return Upload{}, fmt.Errorf("%w: uploads are limited to %d bytes", file.ErrTooLarge, limit)
+	}
+	if err := manager.CheckQuota(ctx, c, userID, quota, length, 1); err != nil {
+		return Upload{}, err
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return Upload{}, err
+	}
+	up := Upload{
+		ID:        id,
+		UserID:    userID,
+		Filename:  cleanFilename(filename),
+		Length:    length,
+		ExpiresAt: time.Now().UTC().Add(UploadTTL),
+	}
+	return up, saveUpload(ctx, c, up, nil)
+}
+
+// GetUpload gets the upload of the user
+func GetUpload(ctx context.Context, c redis.Cmdable, userID int64, id string) (Upload, error) {
+	b, err := c.Get(ctx, uploadKey(id)).Bytes()
+	if err == redis.Nil {
+		return Upload{}, manager.ErrUploadNotFound
+	} else if err != nil {
+		return Upload{}, err
+	}
+
+	up := Upload{}
+	if err := json.Unmarshal(b, &up); err != nil {
+		return Upload{}, err
+	}
+	if up.UserID != userID {
+		return Upload{}, manager.ErrUploadNotFound
+	}
+	return up, nil
+}
+
+// saveUpload keeps the upload and the bytes received past its parts until it expires
+func saveUpload(ctx context.Context, c redis.Cmdable, up Upload, tail []byte) error {
+	b, err := json.Marshal(up)
+	if err != nil {
+		return err
+	}
+	ttl := time.Until(up.ExpiresAt)
+	pipe := c.TxPipeline()
+	pipe.Set(ctx, uploadKey(up.ID), b, ttl)
+	if len(tail) > 0 {
+		pipe.Set(ctx, uploadTailKey(up.ID), tail, ttl)
+	} else {
+		pipe.Del(ctx, uploadTailKey(up.ID))
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// WriteUpload appends the chunk read from r to the upload, offset is where the client thinks the upload stopped
+// the bytes read are kept even if reading fails so the client can resume from the offset of the returned upload
+// the upload is assembled into the blob once all of it is received and the quota of the user has room for it
+func WriteUpload(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, policy file.Policy, quota manager.Quota, userID int64, id string, offset int64, r io.Reader) (Upload, error) {
+	mp, ok := objs.(blobstore.Multipart)
+	if !ok {
+		return Upload{}, manager.ErrUploadUnsupported
+	}
+
+	// only the owner can lock the upload
+	if _, err := GetUpload(ctx, c, userID, id); err != nil {
+		return Upload{}, err
+	}
+	lock, err := randomID()
+	if err != nil {
+		return Upload{}, err
+	}
+	locked, err := c.SetNX(ctx, uploadLockKey(id), lock, uploadLockTTL).Result()
+	if err != nil {
+		return Upload{}, err
+	}
+	if !locked {
+		return Upload{}, manager.ErrUploadLocked
+	}
+	defer unlockUpload.Run(ctx, c, []string{uploadLockKey(id)}, lock)
+
+	// read again since the upload may have moved on before the lock was taken
+	up, err := GetUpload(ctx, c, userID, id)
+	if err != nil {
+		return Upload{}, err
+	}
+	if offset != up.Offset {
+		return up, manager.ErrUploadOffset
+	}
+	if up.Complete {
+		return up, nil
+	}
+
+	tail, err := c.Get(ctx, uploadTailKey(id)).Bytes()
+	if err != nil && err != redis.Nil {
+		return up, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, uploadPartSize))
+	buf.Write(tail)
+	flushed := up.Offset - int64(len(tail))
+
+	// the bytes past the length of the upload are ignored
+	body := io.LimitReader(r, up.Length-up.Offset)
+	var readErr error
+	for {
+		_, readErr = io.CopyN(buf, body, int64(uploadPartSize-buf.Len()))
+		last := flushed+int64(buf.Len()) == up.Length
+		if buf.Len() == uploadPartSize || (last && buf.Len() > 0) {
+			if err := putUploadPart(ctx, mp, bucket, policy, &up, buf); err != nil {
+				if errors.Is(err, file.ErrUnsupportedType) || errors.Is(err, file.ErrTooLarge) || errors.Is(err, manager.ErrUploadPicture) {
+					// the content will not change by resuming, drop the upload
+					removeUpload(ctx, c, id)
+					return up, err
+				}
+				// keep what was received so the part is sent again on the next chunk
+				up.Offset = flushed + int64(buf.Len())
+				if serr := saveUpload(ctx, c, up, buf.Bytes()); serr != nil {
+					return up, serr
+				}
+				return up, err
+			}
+			flushed += up.Parts[len(up.Parts)-1].Size
+			buf.Reset()
+
+			// every part is recorded as soon as it is sent so a dropped connection only loses the current part
+			up.Offset = flushed
+			if err := saveUpload(ctx, c, up, nil); err != nil {
+				return up, err
+			}
+			if ok, err := extendUploadLock.Run(ctx, c, []string{uploadLockKey(id)}, lock, uploadLockTTL.Milliseconds()).Bool(); err != nil || !ok {
+				// the lock expired and another writer may have taken the upload
+				return up, manager.ErrUploadLocked
+			}
+		}
+		if readErr != nil || last {
+			break
+		}
+	}
+
+	up.Offset = flushed + int64(buf.Len())
+	if up.Offset == up.Length {
+		// the parts are kept when there is no room so the client can finish once some is freed
+		if err := saveUpload(ctx, c, up, buf.Bytes()); err != nil {
+			return up, err
+		}
+		if err := manager.ReserveUsage(ctx, c, userID, quota, up.Length, 1); err != nil {
+			return up, err
+		}
+		key := MediaKey(userID, up.MediaID)
+		info, err := mp.CompleteMultipart(ctx, bucket, key, up.MultipartID, up.Parts)
+		if err != nil {
+			if uerr := manager.AddUsage(context.WithoutCancel(ctx), c, userID, -up.Length, -1); uerr != nil {
+				log.Println("AddUsage err:", uerr)
+			}
+			return up, err
+		}
+		up.Complete = true
+		if err := saveUpload(ctx, c, up, nil); err != nil {
+			return up, err
+		}
+		return up, manager.AddUsage(ctx, c, userID, info.Size-up.Length, 0)
+	}
+	if err := saveUpload(ctx, c, up, buf.Bytes()); err != nil {
+		return up, err
+	}
+	if readErr != nil && readErr != io.EOF {
+		return up, readErr
+	}
+	return up, nil
+}
+
+// putUploadPart sends the buffer as the next part of the upload
+// the first part holds the head of the file so it decides the type and the name of the blob
+func putUploadPart(ctx context.Context, mp blobstore.Multipart, bucket string, policy file.Policy, up *Upload, buf *bytes.Buffer) error {
+	if up.MultipartID == "" {
+		info, _, err := policy.Inspect(bytes.NewReader(buf.Bytes()), up.Length)
+		if err != nil {
+			return err
+		}
+		// pictures are resized when they are saved, which needs all of them in memory anyway
+		if info.Category == "image" {
+			return manager.ErrUploadPicture
+		}
+
+		id, err := randomID()
+		if err != nil {
+			return err
+		}
+		mediaID := id + info.Extension
+		multipartID, err := mp.NewMultipart(ctx, bucket, MediaKey(up.UserID, mediaID), blobstore.PutOptions{
+			ContentType: info.MIME,
+			Metadata:    map[string]string{"filename": url.PathEscape(up.Filename)},
+		})
+		if err != nil {
+			return err
+		}
+		up.MediaID, up.MIME, up.MultipartID = mediaID, info.MIME, multipartID
+	}
+
+	key := MediaKey(up.UserID, up.MediaID)
+	part, err := mp.PutPart(ctx, bucket, key, up.MultipartID, len(up.Parts)+1, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return err
+	}
+	up.Parts = append(up.Parts, part)
+	return nil
+}
+
+// AbortUpload drops the upload and what was received of it
+func AbortUpload(ctx context.Context, objs blobstore.BlobStore, c redis.Cmdable, bucket string, userID int64, id string) error {
+	up, err := GetUpload(ctx, c, userID, id)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case up.Complete:
+		err = deleteBlob(ctx, objs, c, bucket, userID, up.MediaID)
+	case up.MultipartID != "":
+		if mp, ok := objs.(blobstore.Multipart); ok {
+			err = mp.AbortMultipart(ctx, bucket, MediaKey(userID, up.MediaID), up.MultipartID)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return removeUpload(ctx, c, id)
+}
+
+// FinishUpload hands the received upload over as a media to be attached to a post
+// an upload can only be finished once
+func FinishUpload(ctx context.Context, c redis.Cmdable, userID int64, id string) (pensive.Media, error) {
+	up, err := GetUpload(ctx, c, userID, id)
+	if err != nil {
+		return pensive.Media{}, err
+	}
+	if !up.Complete {
+		return pensive.Media{}, manager.ErrUploadIncomplete
+	}
+
+	// only the request that removes the upload gets the media
+	n, err := c.Del(ctx, uploadKey(id)).Result()
+	if err != nil {
+		return pensive.Media{}, err
+	}
+	if n == 0 {
+		return pensive.Media{}, manager.ErrUploadNotFound
+	}
+	c.Del(ctx, uploadTailKey(id))
+	return pensive.Media{ID: up.MediaID, Type: up.MIME, Name: up.Filename, Size: up.Length}, nil
+}
+
+func removeUpload(ctx context.Context, c redis.Cmdable, id string) error {
+	return c.Del(ctx, uploadKey(id), uploadTailKey(id)).Err()
+}
@@ -0,0 +1,187 @@
+package managerstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/pkg/blobstore"
+	"github.com/gocs/pensive/pkg/file"
+	"github.com/redis/go-redis/v9"
+)
+
+// textPolicy accepts text large enough for the uploads to span several parts
+var textPolicy = file.Policy{Allowed: []string{"text/plain"}, MaxSize: map[string]int64{"text": 64 << 20}}
+
+// flakyStore fails to put the next fails parts
+type flakyStore struct {
+	*blobstore.FS
+	fails int
+}
+
+func (s *flakyStore) PutPart(ctx context.Context, bucket, key, uploadID string, number int, r io.Reader, size int64) (blobstore.Part, error) {
+	if s.fails > 0 {
+		s.fails--
+		return blobstore.Part{}, errors.New("part failed")
+	}
+	return s.FS.PutPart(ctx, bucket, key, uploadID, number, r, size)
+}
+
+// newTestUpload starts an upload of user 1 and makes its content
+func newTestUpload(t *testing.T, objs blobstore.BlobStore, c redis.Cmdable, length int) (Upload, []byte) {
+	up, err := CreateUpload(context.Background(), objs, c, textPolicy, manager.Quota{}, 1, "a.txt", int64(length))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return up, bytes.Repeat([]byte("a"), length)
+}
+
+// writeUpload writes the chunk of the content from the offset
+func writeUpload(objs blobstore.BlobStore, c redis.Cmdable, quota manager.Quota, id string, content []byte, from, to int) (Upload, error) {
+	return WriteUpload(context.Background(), objs, c, "media", textPolicy, quota, 1, id, int64(from), bytes.NewReader(content[from:to]))
+}
+
+func TestWriteUpload(t *testing.T) {
+	ctx := context.Background()
+	_, objs, c := newTestStore(t)
+	length := uploadPartSize + 100
+	up, content := newTestUpload(t, objs, c, length)
+
+	// a chunk smaller than a part is kept in redis
+	got, err := writeUpload(objs, c, manager.Quota{}, up.ID, content, 0, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Offset != 1000 || len(got.Parts) != 0 {
+		t.Fatalf("WriteUpload() = offset %d, %d parts, want 1000 and none", got.Offset, len(got.Parts))
+	}
+
+	if _, err := writeUpload(objs, c, manager.Quota{}, up.ID, content, 0, 1000); !errors.Is(err, manager.ErrUploadOffset) {
+		t.Fatalf("WriteUpload() at a stale offset error = %v, want %v", err, manager.ErrUploadOffset)
+	}
+	if _, err := WriteUpload(ctx, objs, c, "media", textPolicy, manager.Quota{}, 2, up.ID, 1000, bytes.NewReader(nil)); !errors.Is(err, manager.ErrUploadNotFound) {
+		t.Fatalf("WriteUpload() by another user error = %v, want %v", err, manager.ErrUploadNotFound)
+	}
+	if _, err := FinishUpload(ctx, c, 1, up.ID); !errors.Is(err, manager.ErrUploadIncomplete) {
+		t.Fatalf("FinishUpload() of an incomplete upload error = %v, want %v", err, manager.ErrUploadIncomplete)
+	}
+
+	got, err = writeUpload(objs, c, manager.Quota{}, up.ID, content, 1000, length-50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Offset != int64(length-50) || len(got.Parts) != 1 {
+		t.Fatalf("WriteUpload() = offset %d, %d parts, want %d and one", got.Offset, len(got.Parts), length-50)
+	}
+
+	got, err = writeUpload(objs, c, manager.Quota{}, up.ID, content, length-50, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Complete {
+		t.Fatalf("WriteUpload() = %+v, want it complete", got)
+	}
+	if n, err := c.Exists(ctx, uploadLockKey(up.ID)).Result(); err != nil || n != 0 {
+		t.Errorf("the lock is kept after writing, %v", err)
+	}
+	if u := getUsage(t, c, 1); u != (manager.Usage{Bytes: int64(length), Objects: 1}) {
+		t.Errorf("GetUsage() = %+v, want the upload counted", u)
+	}
+
+	media, err := FinishUpload(ctx, c, 1, up.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := objs.Get(ctx, "media", MediaKey(1, media.ID), 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if b, err := io.ReadAll(r); err != nil || !bytes.Equal(b, content) {
+		t.Errorf("the blob does not hold the upload, %v", err)
+	}
+	if _, err := FinishUpload(ctx, c, 1, up.ID); !errors.Is(err, manager.ErrUploadNotFound) {
+		t.Errorf("second FinishUpload() error = %v, want %v", err, manager.ErrUploadNotFound)
+	}
+}
+
+func TestWriteUploadResume(t *testing.T) {
+	_, fs, c := newTestStore(t)
+	objs := &flakyStore{FS: fs, fails: 1}
+	length := uploadPartSize + 100
+	up, content := newTestUpload(t, objs, c, length)
+
+	// what was read before the part failed is kept
+	got, err := writeUpload(objs, c, manager.Quota{}, up.ID, content, 0, length)
+	if err == nil {
+		t.Fatal("WriteUpload() error = nil, want the part error")
+	}
+	if got.Offset != int64(uploadPartSize) || len(got.Parts) != 0 {
+		t.Fatalf("WriteUpload() = offset %d, %d parts, want %d and none", got.Offset, len(got.Parts), uploadPartSize)
+	}
+
+	got, err = writeUpload(objs, c, manager.Quota{}, up.ID, content, int(got.Offset), length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Complete || len(got.Parts) != 2 {
+		t.Errorf("WriteUpload() = %+v, want it complete in two parts", got)
+	}
+}
+
+func TestWriteUploadQuota(t *testing.T) {
+	_, objs, c := newTestStore(t)
+	length := 1000
+	up, content := newTestUpload(t, objs, c, length)
+
+	// the parts are kept when there is no room left on completion
+	got, err := writeUpload(objs, c, manager.Quota{Bytes: int64(length - 1)}, up.ID, content, 0, length)
+	if !errors.Is(err, manager.ErrQuotaExceeded) {
+		t.Fatalf("WriteUpload() error = %v, want %v", err, manager.ErrQuotaExceeded)
+	}
+	if got.Complete || got.Offset != int64(length) {
+		t.Fatalf("WriteUpload() = %+v, want all of it received but not complete", got)
+	}
+	if u := getUsage(t, c, 1); u != (manager.Usage{}) {
+		t.Errorf("GetUsage() = %+v, want nothing reserved", u)
+	}
+
+	// the client finishes once there is room
+	got, err = writeUpload(objs, c, manager.Quota{}, up.ID, content, length, length)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Complete {
+		t.Errorf("WriteUpload() = %+v, want it complete", got)
+	}
+	if u := getUsage(t, c, 1); u != (manager.Usage{Bytes: int64(length), Objects: 1}) {
+		t.Errorf("GetUsage() = %+v, want the upload counted", u)
+	}
+}
+
+func TestWriteUploadLock(t *testing.T) {
+	ctx := context.Background()
+	_, objs, c := newTestStore(t)
+	up, content := newTestUpload(t, objs, c, 1000)
+
+	if err := c.Set(ctx, uploadLockKey(up.ID), "other", uploadLockTTL).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeUpload(objs, c, manager.Quota{}, up.ID, content, 0, 1000); !errors.Is(err, manager.ErrUploadLocked) {
+		t.Fatalf("WriteUpload() error = %v, want %v", err, manager.ErrUploadLocked)
+	}
+
+	// only the writer holding the token releases or extends the lock
+	if err := unlockUpload.Run(ctx, c, []string{uploadLockKey(up.ID)}, "mine").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := extendUploadLock.Run(ctx, c, []string{uploadLockKey(up.ID)}, "mine", uploadLockTTL.Milliseconds()).Bool(); err != nil || ok {
+		t.Errorf("extendUploadLock() = %v, %v, want the lock of the other writer untouched", ok, err)
+	}
+	if lock, err := c.Get(ctx, uploadLockKey(up.ID)).Result(); err != nil || lock != "other" {
+		t.Errorf("lock = %q, %v, want the token of the other writer", lock, err)
+	}
+}
@@ -0,0 +1,114 @@
+// Package mediagc removes the media blobs no post refers to, e.g. the ones of a post that failed to be added
+//
+// only the shared media bucket is collected, the legacy per-user buckets that managerstore.MigrateMedia
+// copies from are left alone since the oldest posts may still be read from them
+//
+// the collector runs every Interval, a redis lock keeps the app instances from collecting in the same interval:
+//
+//	mediagc:lock  held by the instance that collected last
+package mediagc
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gocs/pensive/internal/managerstore"
+	"github.com/gocs/pensive/pkg/blobstore"
+	"github.com/redis/go-redis/v9"
+)
+
+const lockKey = "mediagc:lock"
+
+// Options configures the collector, zero values use the defaults
+type Options struct {
+	Bucket   string        // the shared media bucket
+	Interval time.Duration // wait between collections; defaults to 6 hours
+	// Grace keeps the orphans younger than it; defaults to 48 hours, it should be longer than managerstore.UploadTTL
+	Grace time.Duration
+	// DryRun moves the orphans to the Quarantine bucket instead of deleting them
+	DryRun     bool
+	Quarantine string // defaults to Bucket with a "-quarantine" suffix
+}
+
+// Collector removes the orphaned media in the background
+type Collector struct {
+	objs blobstore.BlobStore
+	c    redis.Cmdable
+	opts Options
+}
+
+// New creates a collector of the media bucket
+func New(objs blobstore.BlobStore, c redis.Cmdable, opts Options) *Collector {
+	if opts.Interval <= 0 {
+		opts.Interval = 6 * time.Hour
+	}
+	if opts.Grace <= 0 {
+		opts.Grace = 48 * time.Hour
+	}
+	if opts.Quarantine == "" {
+		opts.Quarantine = opts.Bucket + "-quarantine"
+	}
+	return &Collector{objs: objs, c: c, opts: opts}
+}
+
+// Run collects the orphans every interval and blocks until ctx is done
+func (gc *Collector) Run(ctx context.Context) {
+	if gc.opts.DryRun {
+		if err := gc.objs.MakeBucket(ctx, gc.opts.Quarantine); err != nil {
+			log.Println("mediagc MakeBucket err:", err)
+			return
+		}
+	}
+
+	ticker := time.NewTicker(gc.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if err := gc.Collect(ctx); err != nil && ctx.Err() == nil {
+			log.Println("mediagc collect err:", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Collect removes the orphans once unless an instance already collected in this interval
+func (gc *Collector) Collect(ctx context.Context) error {
+	// the lock is kept after a collection so the other instances skip this interval
+	// it expires a bit before the next tick of the instance holding it
+	locked, err := gc.c.SetNX(ctx, lockKey, time.Now().Unix(), gc.opts.Interval*9/10).Result()
+	if err != nil || !locked {
+		return err
+	}
+
+	opts := managerstore.CollectOptions{Grace: gc.opts.Grace}
+	action := "deleted"
+	if gc.opts.DryRun {
+		opts.Quarantine, action = gc.opts.Quarantine, "quarantined"
+	}
+
+	start := time.Now()
+	stats, err := managerstore.CollectOrphans(ctx, gc.objs, gc.c, gc.opts.Bucket, opts)
+	Duration.Observe(time.Since(start).Seconds())
+	// what was collected before an error is counted too
+	Orphans.WithLabelValues(action).Add(float64(stats.Orphans))
+	OrphanBytes.WithLabelValues(action).Add(float64(stats.OrphanBytes))
+	if err != nil {
+		Runs.WithLabelValues("error").Inc()
+		// let any instance try again on its next tick
+		gc.c.Del(context.WithoutCancel(ctx), lockKey)
+		return err
+	}
+
+	Runs.WithLabelValues("ok").Inc()
+	LastRun.SetToCurrentTime()
+	Blobs.WithLabelValues("scanned").Set(float64(stats.Scanned))
+	Blobs.WithLabelValues("referenced").Set(float64(stats.Referenced))
+	Blobs.WithLabelValues("young").Set(float64(stats.Young))
+	log.Printf("mediagc: %d blobs, %d referenced, %d too young, %d %s (%d bytes)",
+		stats.Scanned, stats.Referenced, stats.Young, stats.Orphans, action, stats.OrphanBytes)
+	return nil
+}
@@ -0,0 +1,37 @@
+package mediagc
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// the collectors are registered by the router alongside the http metrics
+var (
+	Runs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "media_gc_runs_total",
+		Help: "Number of orphaned media collections by result.",
+	}, []string{"result"})
+
+	Orphans = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "media_gc_orphans_total",
+		Help: "Number of orphaned blobs collected by action.",
+	}, []string{"action"})
+
+	OrphanBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "media_gc_orphan_bytes_total",
+		Help: "Size of the orphaned blobs collected by action.",
+	}, []string{"action"})
+
+	Blobs = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "media_gc_blobs",
+		Help: "Number of blobs seen by the last collection by state.",
+	}, []string{"state"})
+
+	LastRun = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "media_gc_last_success_timestamp_seconds",
+		Help: "Time the last collection finished without error.",
+	})
+
+	Duration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "media_gc_duration_seconds",
+		Help:    "Duration of the orphaned media collections.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 8),
+	})
+)
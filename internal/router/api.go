@@ -0,0 +1,243 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gocs/pensive"
+	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/internal/managerstore"
+	sessions "github.com/gocs/pensive/internal/session"
+	"github.com/gocs/pensive/pkg/file"
+	"github.com/gocs/pensive/pkg/imaging"
+	"github.com/gorilla/mux"
+)
+
+// apiError is the body of every failed api response
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// apiPostPage is a page of posts with the cursors to its neighbouring pages
+type apiPostPage struct {
+	Posts []pensive.PostPublic `json:"posts"`
+	Older int64                `json:"older,omitempty"`
+	Newer int64                `json:"newer,omitempty"`
+}
+
+// apiProfile is the account details of the current user
+type apiProfile struct {
+	ID         int64     `json:"id"`
+	Username   string    `json:"username"`
+	Email      string    `json:"email"`
+	IsVerified bool      `json:"is_verified"`
+	Followers  int64     `json:"followers"`
+	Following  int64     `json:"following"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logErr(w, "Encode err:", err)
+	}
+}
+
+// writeJSONErr maps the known errors to their status code, anything else is an internal error
+func writeJSONErr(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.Is(err, sessions.ErrUserNotLoggedIn), errors.Is(err, manager.ErrInvalidToken):
+		status = http.StatusUnauthorized
+	case errors.Is(err, manager.ErrPerm), errors.Is(err, manager.ErrTokenScope):
+		status = http.StatusForbidden
+	case errors.Is(err, manager.ErrUserNotFound), errors.Is(err, manager.ErrPostNotFound),
+		errors.Is(err, manager.ErrUploadNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, manager.ErrEmptyForm), errors.Is(err, manager.ErrTooManyAttachments),
+		errors.Is(err, manager.ErrAltRequired), errors.Is(err, manager.ErrAltTooLong),
+		errors.Is(err, manager.ErrUploadIncomplete):
+		status = http.StatusBadRequest
+	case errors.Is(err, manager.ErrUploadOffset):
+		status = http.StatusConflict
+	case errors.Is(err, manager.ErrUploadLocked):
+		status = http.StatusLocked
+	case errors.Is(err, manager.ErrUploadUnsupported):
+		status = http.StatusNotImplemented
+	case errors.Is(err, file.ErrUnsupportedType), errors.Is(err, manager.ErrUploadPicture), errors.Is(err, imaging.ErrUnsupportedFormat):
+		status = http.StatusUnsupportedMediaType
+	case errors.Is(err, file.ErrTooLarge), errors.Is(err, manager.ErrQuotaExceeded), errors.Is(err, imaging.ErrTooManyPixels),
+		errors.As(err, &tooLarge):
+		status = http.StatusRequestEntityTooLarge
+	}
+
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		logErr(w, "api err:", err)
+		message = http.StatusText(status)
+	}
+	writeJSON(w, status, apiError{Status: status, Message: message})
+}
+
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusNotFound, apiError{Status: http.StatusNotFound, Message: "not found"})
+}
+
+// apiAuth gets the current user, otherwise responds unauthorized
+func (a *App) apiAuth(w http.ResponseWriter, r *http.Request) (*manager.User, bool) {
+	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
+	if err == nil && self == nil {
+		err = sessions.ErrUserNotLoggedIn
+	}
+	if err != nil {
+		// anything else than the token errors came from reading the session cookie
+		if !errors.Is(err, manager.ErrInvalidToken) && !errors.Is(err, manager.ErrTokenScope) {
+			err = sessions.ErrUserNotLoggedIn
+		}
+		writeJSONErr(w, err)
+		return nil, false
+	}
+	return self, true
+}
+
+func (a *App) apiTimeline(w http.ResponseWriter, r *http.Request) {
+	self, ok := a.apiAuth(w, r)
+	if !ok {
+		return
+	}
+
+	ps, cursor, err := managerstore.ListTimeline(r.Context(), a.objs, a.client, self.ID(), pageFromQuery(r))
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPostPage{Posts: ps, Older: cursor.Older, Newer: cursor.Newer})
+}
+
+func (a *App) apiExplore(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.apiAuth(w, r); !ok {
+		return
+	}
+
+	ps, cursor, err := managerstore.ListPost(r.Context(), a.objs, a.client, pageFromQuery(r))
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPostPage{Posts: ps, Older: cursor.Older, Newer: cursor.Newer})
+}
+
+func (a *App) apiUserPosts(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.apiAuth(w, r); !ok {
+		return
+	}
+
+	username := mux.Vars(r)["username"]
+	user, err := manager.GetUserByName(r.Context(), a.client, username)
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+
+	ps, cursor, err := managerstore.ListPostByUserID(r.Context(), a.objs, a.client, user.ID(), pageFromQuery(r))
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPostPage{Posts: ps, Older: cursor.Older, Newer: cursor.Newer})
+}
+
+func (a *App) apiPost(w http.ResponseWriter, r *http.Request) {
+	if _, ok := a.apiAuth(w, r); !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	user, err := manager.GetUserByName(r.Context(), a.client, vars["username"])
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+
+	postID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		writeJSONErr(w, manager.ErrPostNotFound)
+		return
+	}
+
+	ps, err := managerstore.GetPost(r.Context(), a.objs, a.client, user.ID(), postID)
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, ps)
+}
+
+func (a *App) apiCreatePost(w http.ResponseWriter, r *http.Request) {
+	self, ok := a.apiAuth(w, r)
+	if !ok {
+		return
+	}
+
+	post, err := a.createPost(w, r, self)
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+
+	ps, err := managerstore.GetPost(r.Context(), a.objs, a.client, self.ID(), post.ID())
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, ps)
+}
+
+func (a *App) apiMe(w http.ResponseWriter, r *http.Request) {
+	self, ok := a.apiAuth(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := manager.GetUser(r.Context(), a.client, self)
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+
+	isVerified, err := self.IsVerified(r.Context())
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+
+	followers, err := manager.FollowerCount(r.Context(), a.client, u.ID)
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+
+	following, err := manager.FollowingCount(r.Context(), a.client, u.ID)
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, apiProfile{
+		ID:         u.ID,
+		Username:   u.Username,
+		Email:      u.Email,
+		IsVerified: isVerified,
+		Followers:  followers,
+		Following:  following,
+		CreatedAt:  *u.CreatedAt,
+		UpdatedAt:  *u.UpdatedAt,
+	})
+}
@@ -1,44 +1,104 @@
 package router
 
 import (
-	"io"
+	"errors"
 	"log"
+	"mime"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gocs/pensive/internal/manager"
+	"github.com/gocs/pensive/internal/managerstore"
+	"github.com/gocs/pensive/pkg/blobstore"
 	"github.com/gorilla/mux"
 )
 
+// presignTTL is how long a redirect to the blob store stays usable
+// it is kept short since anyone holding the url can read the object
+const presignTTL = 5 * time.Minute
+
+// GetObject serves an attachment of the user in the path to a logged-in user
+// the object is either streamed with range support or, with Config.MediaDelivery "presign",
+// served by redirecting to a short-lived presigned url of the blob store
 func (a *App) GetObject(w http.ResponseWriter, r *http.Request) {
-	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
-	if err != nil {
+	// the uploads must never be sniffed into another type, e.g. a text file into html
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if _, err := manager.AuthSelf(r, a.session, a.client, UserIDSession); err != nil {
 		log.Println("unauthorized:", err)
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
 
-	u, err := self.Username(r.Context())
+	vars := mux.Vars(r)
+	owner, err := manager.GetUserByName(r.Context(), a.client, vars["username"])
 	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusFound)
+		http.NotFound(w, r)
 		return
 	}
 
-	filename := mux.Vars(r)["filename"]
-	if filename == "" {
-		http.Redirect(w, r, "/login", http.StatusNotFound)
+	// the media are keyed by the owner ID so the url keeps working after a rename
+	filename := vars["filename"]
+	key := managerstore.MediaKey(owner.ID(), filename)
+	info, err := a.objs.Stat(r.Context(), a.bucket, key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		logErr(w, "Stat err:", err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
 		return
 	}
 
-	f, err := a.objs.GetObject(r.Context(), u, filename)
-	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusNotFound)
-		return
+	contentType := info.ContentType
+	if contentType == "" || contentType == "none" {
+		contentType = "application/octet-stream"
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	_, err = io.Copy(w, f)
-	if err != nil {
-		http.Redirect(w, r, "/login", http.StatusNotFound)
-		return
+	// only the media the feed shows are displayed by the browser, the others are downloaded
+	// the presigned urls cannot carry nosniff, so this also keeps them from being rendered
+	disposition := "attachment"
+	switch strings.SplitN(contentType, "/", 2)[0] {
+	case "image", "audio", "video":
+		disposition = "inline"
+	}
+	// objects are stored under generated names, the original one is kept as metadata
+	if name, err := url.PathUnescape(info.Metadata["filename"]); err == nil && name != "" {
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": name})
+	}
+
+	if a.presign {
+		rp := make(url.Values)
+		rp.Set("response-content-type", contentType)
+		rp.Set("response-content-disposition", disposition)
+		u, err := a.objs.PresignGet(r.Context(), a.bucket, key, presignTTL, rp)
+		if err == nil {
+			// the url expires so the redirect itself must not be cached
+			w.Header().Set("Cache-Control", "no-store")
+			http.Redirect(w, r, u.String(), http.StatusFound)
+			return
+		}
+		// the blob stores without signed urls fall back to streaming
+		if !errors.Is(err, blobstore.ErrNotSupported) {
+			logErr(w, "PresignGet err:", err)
+			http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			return
+		}
+	}
+
+	// the blob is read lazily with ranged gets as ServeContent seeks
+	f := blobstore.NewReadSeeker(r.Context(), a.objs, a.bucket, key, info.Size)
+	defer f.Close()
+
+	// ServeContent handles Range, If-Range and If-None-Match against the etag, and sets Content-Length
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", disposition)
+	// some stores, e.g. the seaweedfs filer, may not tell the etag
+	if info.ETag != "" {
+		w.Header().Set("ETag", `"`+info.ETag+`"`)
 	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, filename, info.LastModified, f)
 }
@@ -4,6 +4,9 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/gocs/pensive/internal/mailqueue"
+	"github.com/gocs/pensive/internal/mediagc"
+
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -13,6 +16,18 @@ func init() {
 	prometheus.Register(totalRequests)
 	prometheus.Register(responseStatus)
 	prometheus.Register(httpDuration)
+
+	prometheus.Register(mailqueue.Sent)
+	prometheus.Register(mailqueue.Failures)
+	prometheus.Register(mailqueue.DeadLetters)
+	prometheus.Register(mailqueue.Depth)
+
+	prometheus.Register(mediagc.Runs)
+	prometheus.Register(mediagc.Orphans)
+	prometheus.Register(mediagc.OrphanBytes)
+	prometheus.Register(mediagc.Blobs)
+	prometheus.Register(mediagc.LastRun)
+	prometheus.Register(mediagc.Duration)
 }
 
 var (
@@ -2,16 +2,28 @@ package router
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/gocs/pensive"
+	"github.com/gocs/pensive/internal/mailqueue"
 	"github.com/gocs/pensive/internal/manager"
 	"github.com/gocs/pensive/internal/managerstore"
 	sessions "github.com/gocs/pensive/internal/session"
+	"github.com/gocs/pensive/pkg/blobstore"
 	"github.com/gocs/pensive/pkg/file"
+	"github.com/gocs/pensive/pkg/mail"
 	"github.com/gocs/pensive/pkg/objectstore"
+	"github.com/gocs/pensive/pkg/store"
+	"github.com/gocs/pensive/pkg/token"
 	"github.com/gocs/pensive/tmpl"
 	"github.com/redis/go-redis/v9"
 
@@ -20,47 +32,131 @@ import (
 )
 
 type Config struct {
-	SessionKey, RedisAddr, RedisPassword, GmailEmail,
-	GmailPassword, AccessSecret, MinioEndpoint, MinioUser, MinioPassword string
+	SessionKey, RedisAddr, RedisPassword, AccessSecret,
+	MinioEndpoint, MinioUser, MinioPassword string
+
+	// BaseURL is where the users reach the app, e.g. "https://pensive.example"
+	// the links in the mails are built from it rather than from the Host header the client sends
+	BaseURL string
+
+	// MailTransport is either "smtp", or "maildir" which writes the mails to MaildirPath
+	MailTransport, MailFrom, MaildirPath string
+	// MailQueue is where the handlers queue the mails, it is the one whose workers run
+	// a queue with the default options is used when it is nil
+	MailQueue *mailqueue.Queue
+	// SMTPTLS is either "starttls", "tls", or "none"
+	SMTPHost, SMTPPort, SMTPUsername, SMTPPassword, SMTPTLS string
+
+	// SessionStore is either "cookie" which keeps the session in the cookie,
+	// or "redis" which keeps it server-side so it can be listed and revoked
+	SessionStore string
+	// TrustedProxies lists the addresses or CIDRs of the reverse proxies in front of the app
+	// the client address of the sessions is read from their X-Forwarded-For and X-Real-IP headers
+	TrustedProxies []string
+
+	// TokenKeys signs the emailed tokens, written as "kid:secret,kid:secret"
+	// the first key signs new tokens and the others are still accepted while rotating keys
+	// AccessSecret is used as the only key when it is empty
+	TokenKeys string
+	// TokenTTLs overrides token.DefaultTTLs per purpose
+	TokenTTLs map[token.Purpose]time.Duration
+
+	// BlobBackend is where the attachments are stored: "minio", "seaweedfs" through the filer at SeaweedFilerURL,
+	// or "fs" which writes them under BlobPath
+	BlobBackend, BlobPath, SeaweedFilerURL string
+	// MediaBucket is the bucket shared by the media of every user
+	MediaBucket string
+
+	// MediaDelivery is either "stream" which serves attachments through the app,
+	// or "presign" which redirects to short-lived presigned urls of the object store
+	// attachments are streamed when the blob store cannot presign urls
+	MediaDelivery string
+	// MediaAllowed lists the MIME types that can be uploaded, all the sniffed types when empty
+	MediaAllowed []string
+	// MediaMaxSizes overrides the upload size limits in bytes per category, e.g. "image" or "video"
+	MediaMaxSizes map[string]int64
+	// StorageQuota is the quota of the users who have none of their own, see manager.SetQuota
+	StorageQuota manager.Quota
 }
 
-func New(ctx context.Context, config *Config) (*mux.Router, error) {
+// mediaPolicy is the upload policy from the file.DefaultPolicy and the overrides of the config
+func mediaPolicy(config *Config) file.Policy {
+	p := file.DefaultPolicy()
+	if len(config.MediaAllowed) > 0 {
+		p.Allowed = config.MediaAllowed
+	}
+	for category, size := range config.MediaMaxSizes {
+		if size > 0 {
+			p.MaxSize[category] = size
+		}
+	}
+	return p
+}
+
+// New makes the router of the app on the redis client and the blob store it shares with the background workers
+func New(ctx context.Context, config *Config, c redis.Cmdable, objs blobstore.BlobStore) (*mux.Router, error) {
 	r := mux.NewRouter()
 
-	c, err := manager.NewManager(ctx, config.RedisAddr, config.RedisPassword)
-	if err != nil {
-		return nil, err
+	// the session cookie is only sent over https when the app is reached over it
+	secure := strings.HasPrefix(config.BaseURL, "https://")
+	s := sessions.New(config.SessionKey, "session", secure)
+	if config.SessionStore == "redis" {
+		proxies, err := sessions.ParseProxies(config.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		s = sessions.NewRedis(c, config.SessionKey, "session", sessions.DefaultTTL, UserIDSession, proxies, secure)
 	}
 
-	s := sessions.New(config.SessionKey, "session")
+	if err := objs.MakeBucket(ctx, config.MediaBucket); err != nil {
+		return nil, err
+	}
 
-	objs, err := objectstore.New(objectstore.Config{
-		Endpoint:        config.MinioEndpoint,
-		AccessKeyID:     config.MinioUser,
-		SecretAccessKey: config.MinioPassword,
-	})
+	keys := []token.Key{{ID: "default", Secret: config.AccessSecret}}
+	if config.TokenKeys != "" {
+		var err error
+		keys, err = token.ParseKeys(config.TokenKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tokens, err := token.NewIssuer(c, keys, config.TokenTTLs)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
+	}
+
+	// mails are queued and sent by the workers started with mailqueue.Queue.Run
+	mailer := config.MailQueue
+	if mailer == nil {
+		mailer = mailqueue.New(c, mailqueue.Options{})
 	}
 
 	// handler controllers
 	a := App{
-		client:  c.Cmdable,
+		client:  c,
 		session: s,
 		objs:    objs,
+		bucket:  config.MediaBucket,
+		presign: config.MediaDelivery == "presign",
+		media:   mediaPolicy(config),
+		quota:   config.StorageQuota,
 	}
-	ul := UserLogin{client: c.Cmdable, session: s}
-	ur := UserRegister{
-		client:  c.Cmdable,
+	ul := UserLogin{client: c, session: s}
+	ur := UserRegister{client: c, session: s}
+	urs := UserReset{
+		client:  c,
 		session: s,
-		objs:    objs,
+		mailer:  mailer,
+		tokens:  tokens,
+		baseURL: strings.TrimSuffix(config.BaseURL, "/"),
 	}
 	us := UserSettings{
-		client:       c.Cmdable,
-		session:      s,
-		fromEmail:    config.GmailEmail,
-		password:     config.GmailPassword,
-		accessSecret: config.AccessSecret,
+		client:  c,
+		session: s,
+		mailer:  mailer,
+		tokens:  tokens,
+		quota:   config.StorageQuota,
+		baseURL: strings.TrimSuffix(config.BaseURL, "/"),
 	}
 
 	// middlewares
@@ -69,37 +165,123 @@ func New(ctx context.Context, config *Config) (*mux.Router, error) {
 	// routers
 	r.HandleFunc("/", a.home).Methods("GET")
 	r.Handle("/home", http.RedirectHandler("/", http.StatusFound)).Methods("GET")
+	r.HandleFunc("/explore", a.explore).Methods("GET")
 	r.HandleFunc("/@{username}", a.profile).Methods("GET")
+	r.HandleFunc("/@{username}/follow", a.follow).Methods("POST")
+	r.HandleFunc("/@{username}/unfollow", a.unfollow).Methods("POST")
+	r.HandleFunc("/@{username}/post/{id:[0-9]+}", a.permalink).Methods("GET")
 	r.HandleFunc("/@{username}/{filename}", a.GetObject).Methods("GET")
 	r.HandleFunc("/post", a.homePost).Methods("POST")
+	r.HandleFunc("/post/{id:[0-9]+}/edit", a.editPost).Methods("POST")
+	r.HandleFunc("/post/{id:[0-9]+}/delete", a.deletePost).Methods("POST")
 	r.HandleFunc("/login", ul.Get).Methods("GET")
 	r.HandleFunc("/login", ul.Post).Methods("POST")
 	r.HandleFunc("/register", ur.Get).Methods("GET")
 	r.HandleFunc("/register", ur.Post).Methods("POST")
 	r.HandleFunc("/logout", a.userLogout).Methods("POST")
+	r.HandleFunc("/forgot", urs.GetForgot).Methods("GET")
+	r.HandleFunc("/forgot", urs.PostForgot).Methods("POST")
+	r.HandleFunc("/reset", urs.GetReset).Methods("GET")
+	r.HandleFunc("/reset", urs.PostReset).Methods("POST")
 
 	// settings routings
 	r.HandleFunc("/settings", us.Get).Methods("GET")
 	r.HandleFunc("/settings/profile", us.GetProfile).Methods("GET")
 	r.HandleFunc("/settings/profile", us.SetProfile).Methods("POST")
+	r.HandleFunc("/settings/profile/posting", us.SetPosting).Methods("POST")
 	r.HandleFunc("/settings/privacy", us.GetPrivacy).Methods("GET")
 	r.HandleFunc("/settings/privacy", us.SetPrivacy).Methods("POST")
+	r.HandleFunc("/settings/privacy/sessions/revoke", us.RevokeAllSessions).Methods("POST")
+	r.HandleFunc("/settings/privacy/sessions/{id:[0-9a-f]+}/revoke", us.RevokeSession).Methods("POST")
 	r.HandleFunc("/settings/account", us.GetAccount).Methods("GET")
 	r.HandleFunc("/settings/account", us.SetAccount).Methods("POST")
+	r.HandleFunc("/settings/account/confirm", us.ConfirmEmailChange).Methods("GET")
+	r.HandleFunc("/settings/tokens", us.GetTokens).Methods("GET")
+	r.HandleFunc("/settings/tokens", us.CreateToken).Methods("POST")
+	r.HandleFunc("/settings/tokens/{id:[0-9]+}/revoke", us.RevokeToken).Methods("POST")
 	r.HandleFunc("/verify", us.AcceptEmailVerif).Methods("GET")
 	r.HandleFunc("/verify", us.VerifyEmail).Methods("POST")
 
+	// json api routings
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/timeline", a.apiTimeline).Methods("GET")
+	api.HandleFunc("/explore", a.apiExplore).Methods("GET")
+	api.HandleFunc("/me", a.apiMe).Methods("GET")
+	api.HandleFunc("/posts", a.apiCreatePost).Methods("POST")
+	api.HandleFunc("/uploads", a.apiUploadOptions).Methods("OPTIONS")
+	api.HandleFunc("/uploads", a.apiCreateUpload).Methods("POST")
+	api.HandleFunc("/uploads/{id:[0-9a-f]+}", a.apiHeadUpload).Methods("HEAD")
+	api.HandleFunc("/uploads/{id:[0-9a-f]+}", a.apiPatchUpload).Methods("PATCH")
+	api.HandleFunc("/uploads/{id:[0-9a-f]+}", a.apiDeleteUpload).Methods("DELETE")
+	api.HandleFunc("/users/{username}/posts", a.apiUserPosts).Methods("GET")
+	api.HandleFunc("/users/{username}/posts/{id:[0-9]+}", a.apiPost).Methods("GET")
+	api.NotFoundHandler = http.HandlerFunc(apiNotFound)
+
 	// Prometheus endpoint
 	r.Handle("/prometheus", promhttp.Handler())
 
 	return r, nil
 }
 
+// bucketRegion is the region of the buckets made in minio
+const bucketRegion = "us-east-1"
+
+// NewBlobStore creates the blob store selected by the config
+func NewBlobStore(config *Config) (blobstore.BlobStore, error) {
+	switch config.BlobBackend {
+	case "", "minio":
+		objs, err := objectstore.New(objectstore.Config{
+			Endpoint:        config.MinioEndpoint,
+			AccessKeyID:     config.MinioUser,
+			SecretAccessKey: config.MinioPassword,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return blobstore.NewMinio(objs, bucketRegion), nil
+	case "seaweedfs":
+		c, err := store.New(store.Options{FilerURL: config.SeaweedFilerURL})
+		if err != nil {
+			return nil, err
+		}
+		return blobstore.NewSeaweedFS(c), nil
+	case "fs":
+		return blobstore.NewFS(config.BlobPath)
+	}
+	return nil, blobstore.ErrUnknownBackend
+}
+
+// NewMailer creates the mail transport selected by the config
+func NewMailer(config *Config) (mail.Mailer, error) {
+	switch config.MailTransport {
+	case "", "smtp":
+		return mail.NewSMTP(mail.SMTPConfig{
+			Host:     config.SMTPHost,
+			Port:     config.SMTPPort,
+			Username: config.SMTPUsername,
+			Password: config.SMTPPassword,
+			From:     config.MailFrom,
+			TLS:      mail.TLSMode(config.SMTPTLS),
+		})
+	case "maildir":
+		return mail.NewMaildir(config.MaildirPath, config.MailFrom)
+	}
+	return nil, mail.ErrUnknownTransport
+}
+
 // App is the struct for the homepage or the user profile homepage
 type App struct {
 	client  redis.Cmdable
 	session *sessions.Session
-	objs    *objectstore.ObjectStore
+	objs    blobstore.BlobStore
+	// bucket keeps the media of every user, see managerstore.MediaKey
+	bucket string
+	// presign serves attachments by redirecting to the object store instead of streaming them
+	presign bool
+	// media is what can be uploaded
+	media file.Policy
+	// quota is the default storage quota of the users
+	quota manager.Quota
 }
 
 const (
@@ -110,6 +292,38 @@ func logErr(w http.ResponseWriter, err ...interface{}) {
 	log.Println(err...)
 }
 
+// pageFromQuery reads the "before", "after", and "size" pagination queries
+// invalid values are ignored and fallback to the newest page
+func pageFromQuery(r *http.Request) manager.Page {
+	q := r.URL.Query()
+	before, _ := strconv.ParseInt(q.Get("before"), 10, 64)
+	after, _ := strconv.ParseInt(q.Get("after"), 10, 64)
+	size, _ := strconv.ParseInt(q.Get("size"), 10, 64)
+	return manager.Page{Before: before, After: after, Size: size}
+}
+
+// cursorURLs builds the older and newer page links of the current path
+// the link is empty when there is no page in that direction
+func cursorURLs(r *http.Request, cursor manager.Cursor) (older, newer string) {
+	size := r.URL.Query().Get("size")
+	link := func(key string, id int64) string {
+		q := url.Values{}
+		q.Set(key, strconv.FormatInt(id, 10))
+		if size != "" {
+			q.Set("size", size)
+		}
+		return fmt.Sprint(r.URL.Path, "?", q.Encode())
+	}
+
+	if cursor.Older != 0 {
+		older = link("before", cursor.Older)
+	}
+	if cursor.Newer != 0 {
+		newer = link("after", cursor.Newer)
+	}
+	return older, newer
+}
+
 func (a *App) home(w http.ResponseWriter, r *http.Request) {
 	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
 	if err != nil {
@@ -124,22 +338,33 @@ func (a *App) home(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ps, err := managerstore.ListPost(r.Context(), a.objs, a.client)
+	ps, cursor, err := managerstore.ListTimeline(r.Context(), a.objs, a.client, self.ID(), pageFromQuery(r))
 	if err != nil {
-		logErr(w, "ListPost err:", err)
+		logErr(w, "ListTimeline err:", err)
 		return
 	}
 
+	older, newer := cursorURLs(r, cursor)
 	p := tmpl.HomeParams{
 		Title:       "Posts",
 		DisplayForm: true,
 		Name:        fmt.Sprint("@", u.Username),
 		Posts:       ps,
+		OlderURL:    older,
+		NewerURL:    newer,
+	}
+	if r.URL.Query().Get("quota") != "" {
+		storage, err := storageParams(r.Context(), a.client, self.ID(), a.quota)
+		if err != nil {
+			logErr(w, "storageParams err:", err)
+			return
+		}
+		p.QuotaExceeded = &storage
 	}
 	tmpl.Home(w, p)
 }
 
-func (a *App) homePost(w http.ResponseWriter, r *http.Request) {
+func (a *App) explore(w http.ResponseWriter, r *http.Request) {
 	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
 	if err != nil {
 		log.Println("unauthorized:", err)
@@ -147,50 +372,237 @@ func (a *App) homePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := self.ID()
-	u, err := self.Username(r.Context())
+	u, err := manager.GetUser(r.Context(), a.client, self)
+	if err != nil {
+		logErr(w, "GetUser err:", err)
+		return
+	}
+
+	ps, cursor, err := managerstore.ListPost(r.Context(), a.objs, a.client, pageFromQuery(r))
+	if err != nil {
+		logErr(w, "ListPost err:", err)
+		return
+	}
+
+	older, newer := cursorURLs(r, cursor)
+	p := tmpl.HomeParams{
+		Title:       "Explore",
+		DisplayForm: true,
+		Name:        fmt.Sprint("@", u.Username),
+		Posts:       ps,
+		OlderURL:    older,
+		NewerURL:    newer,
+	}
+	tmpl.Home(w, p)
+}
+
+func (a *App) homePost(w http.ResponseWriter, r *http.Request) {
+	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
 	if err != nil {
 		log.Println("unauthorized:", err)
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
 
-	mediaSource := "media-source"
-	filename := ""
-	mf, fh, err := r.FormFile(mediaSource)
-	if err == nil {
-		defer mf.Close()
+	if _, err := a.createPost(w, r, self); errors.Is(err, manager.ErrQuotaExceeded) {
+		logErr(w, "createPost err:", err)
+		http.Redirect(w, r, "/?quota=exceeded", http.StatusFound)
+		return
+	} else if err != nil {
+		logErr(w, "createPost err:", err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// createPost saves the uploaded media of the post form if there is one, then adds the post
+func (a *App) createPost(w http.ResponseWriter, r *http.Request, self *manager.User) (*manager.Post, error) {
+	// the form is limited before it is parsed since the files are only checked once they are received
+	// the slack leaves room for the body, the alt texts and the multipart boundaries
+	r.Body = http.MaxBytesReader(w, r.Body, manager.MaxAttachments*a.maxMediaSize()+1<<20)
+	// the files past the memory limit are kept in temporary files
+	if err := r.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+		return nil, err
+	}
 
-		opts := objectstore.PutObjectOptions{
-			ContentType: file.DetectContentType(fh.Filename),
+	// the alt texts are in the same order as the files
+	mediaSource, mediaAlt := "media-source", "media-alt"
+	fhs, alts := []*multipart.FileHeader{}, []string{}
+	if r.MultipartForm != nil {
+		fhs, alts = r.MultipartForm.File[mediaSource], r.MultipartForm.Value[mediaAlt]
+	}
+	// the files sent beforehand as resumable uploads come after the files of the form
+	uploadIDs, uploadAlts := r.PostForm["upload-id"], r.PostForm["upload-alt"]
+	if len(fhs)+len(uploadIDs) > manager.MaxAttachments {
+		return nil, manager.ErrTooManyAttachments
+	}
+
+	// rejects early before any file is saved, each file reserves its room in the quota when it is saved
+	// and the resumable uploads when they are finished
+	size := int64(0)
+	for _, fh := range fhs {
+		size += fh.Size
+	}
+	if err := manager.CheckQuota(r.Context(), a.client, self.ID(), a.quota, size, int64(len(fhs))); err != nil {
+		return nil, err
+	}
+
+	requireAlt, err := self.RequiresAlt(r.Context())
+	if err != nil {
+		return nil, err
+	}
+
+	attachments, err := a.saveAttachments(r.Context(), self.ID(), fhs, alts, requireAlt)
+	if err != nil {
+		return nil, err
+	}
+	uploaded, err := a.finishUploads(r.Context(), self.ID(), uploadIDs, uploadAlts)
+	if err != nil {
+		a.removeAttachments(r.Context(), self.ID(), attachments)
+		return nil, err
+	}
+	attachments = append(attachments, uploaded...)
+
+	body := r.FormValue("post")
+	return manager.PostUpdate(r.Context(), a.client, self.ID(), body, attachments)
+}
+
+// saveAttachments saves the uploaded files in order; if one fails the ones saved before it are removed
+// alts are the alt texts of the files, files past its end have none
+func (a *App) saveAttachments(ctx context.Context, userID int64, fhs []*multipart.FileHeader, alts []string, requireAlt bool) ([]pensive.Media, error) {
+	attachments := []pensive.Media{}
+	for i, fh := range fhs {
+		alt := ""
+		if i < len(alts) {
+			alt = strings.TrimSpace(alts[i])
 		}
 
-		_, err := a.objs.SaveObject(r.Context(), u, fh.Filename, mf, fh.Size, opts)
+		media, err := a.saveAttachment(ctx, userID, fh, alt, requireAlt)
 		if err != nil {
-			log.Println(w, "SaveObject err:", err)
-			http.Redirect(w, r, "/", http.StatusFound)
-			return
+			a.removeAttachments(ctx, userID, attachments)
+			return nil, err
 		}
-		filename = fh.Filename
-	} else if err == http.ErrMissingFile {
-		logErr(w, "FormFile skip:", err)
-	} else {
-		logErr(w, "FormFile err:", err)
-		http.Redirect(w, r, "/", http.StatusFound)
+		attachments = append(attachments, media)
+	}
+	return attachments, nil
+}
+
+// finishUploads takes the completed resumable uploads in order; if one fails the ones taken before it are removed
+// alts are the alt texts of the uploads, uploads past its end have none
+func (a *App) finishUploads(ctx context.Context, userID int64, ids, alts []string) ([]pensive.Media, error) {
+	attachments := []pensive.Media{}
+	for i, id := range ids {
+		alt := ""
+		if i < len(alts) {
+			alt = strings.TrimSpace(alts[i])
+		}
+		if utf8.RuneCountInString(alt) > manager.MaxAltLength {
+			a.removeAttachments(ctx, userID, attachments)
+			return nil, manager.ErrAltTooLong
+		}
+		media, err := managerstore.FinishUpload(ctx, a.client, userID, id)
+		if err != nil {
+			a.removeAttachments(ctx, userID, attachments)
+			return nil, err
+		}
+		media.Alt = alt
+		attachments = append(attachments, media)
+	}
+	return attachments, nil
+}
+
+// removeAttachments removes the media saved for a post that could not be added
+func (a *App) removeAttachments(ctx context.Context, userID int64, attachments []pensive.Media) {
+	for _, media := range attachments {
+		if err := managerstore.RemoveMedia(ctx, a.objs, a.client, a.bucket, userID, media); err != nil {
+			log.Println("RemoveMedia err:", err)
+		}
+	}
+}
+
+func (a *App) saveAttachment(ctx context.Context, userID int64, fh *multipart.FileHeader, alt string, requireAlt bool) (pensive.Media, error) {
+	if utf8.RuneCountInString(alt) > manager.MaxAltLength {
+		return pensive.Media{}, manager.ErrAltTooLong
+	}
+
+	mf, err := fh.Open()
+	if err != nil {
+		return pensive.Media{}, err
+	}
+	defer mf.Close()
+
+	// the content decides the type, not the filename the client sent
+	info, content, err := a.media.Inspect(mf, fh.Size)
+	if err != nil {
+		return pensive.Media{}, err
+	}
+	if requireAlt && info.Category == "image" && alt == "" {
+		return pensive.Media{}, manager.ErrAltRequired
+	}
+
+	media, err := managerstore.SaveMedia(ctx, a.objs, a.client, a.bucket, userID, a.quota, content, fh.Filename, fh.Size, info)
+	if err != nil {
+		return pensive.Media{}, err
+	}
+	media.Alt = alt
+	return media, nil
+}
+
+// postRedirect goes back to the page where the post form was submitted
+func postRedirect(w http.ResponseWriter, r *http.Request) {
+	to := r.Referer()
+	if to == "" {
+		to = "/"
+	}
+	http.Redirect(w, r, to, http.StatusFound)
+}
+
+func (a *App) editPost(w http.ResponseWriter, r *http.Request) {
+	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
+	if err != nil {
+		log.Println("unauthorized:", err)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	postID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		logErr(w, "ParseInt err:", err)
+		postRedirect(w, r)
 		return
 	}
 
 	body := r.FormValue("post")
-	if err := manager.PostUpdate(r.Context(), a.client, userID, body, filename); err != nil {
-		logErr(w, "PostUpdate err:", err)
+	if err := manager.EditPost(r.Context(), a.client, self.ID(), postID, body); err != nil {
+		logErr(w, "EditPost err:", err)
+	}
+	postRedirect(w, r)
+}
+
+func (a *App) deletePost(w http.ResponseWriter, r *http.Request) {
+	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
+	if err != nil {
+		log.Println("unauthorized:", err)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	postID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		logErr(w, "ParseInt err:", err)
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
+
+	if err := managerstore.DeletePost(r.Context(), a.objs, a.client, a.bucket, self.ID(), postID); err != nil {
+		logErr(w, "DeletePost err:", err)
+	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
 func (a *App) profile(w http.ResponseWriter, r *http.Request) {
-	_, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
+	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
 	if err != nil {
 		log.Println("unauthorized:", err)
 		http.Redirect(w, r, "/login", http.StatusFound)
@@ -214,17 +626,125 @@ func (a *App) profile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ps, err := managerstore.ListPostByUserID(r.Context(), a.objs, a.client, u.ID)
+	ps, cursor, err := managerstore.ListPostByUserID(r.Context(), a.objs, a.client, u.ID, pageFromQuery(r))
 	if err != nil {
 		logErr(w, "ListPost err:", err)
 		return
 	}
 
+	followers, err := manager.FollowerCount(r.Context(), a.client, u.ID)
+	if err != nil {
+		logErr(w, "FollowerCount err:", err)
+		return
+	}
+
+	following, err := manager.FollowingCount(r.Context(), a.client, u.ID)
+	if err != nil {
+		logErr(w, "FollowingCount err:", err)
+		return
+	}
+
+	isFollowing, err := manager.IsFollowing(r.Context(), a.client, self.ID(), u.ID)
+	if err != nil {
+		logErr(w, "IsFollowing err:", err)
+		return
+	}
+
+	older, newer := cursorURLs(r, cursor)
 	p := tmpl.HomeParams{
 		Title:       "Posts",
 		Name:        fmt.Sprint("@", u.Username),
 		DisplayForm: true,
 		Posts:       ps,
+		OlderURL:    older,
+		NewerURL:    newer,
+		Profile: &tmpl.FollowParams{
+			Username:    u.Username,
+			Followers:   followers,
+			Following:   following,
+			IsSelf:      u.ID == self.ID(),
+			IsFollowing: isFollowing,
+		},
 	}
 	tmpl.Home(w, p)
 }
+
+func (a *App) follow(w http.ResponseWriter, r *http.Request) {
+	a.setFollow(w, r, manager.Follow)
+}
+
+func (a *App) unfollow(w http.ResponseWriter, r *http.Request) {
+	a.setFollow(w, r, manager.Unfollow)
+}
+
+// setFollow applies either follow or unfollow from the current user to the user in the path
+func (a *App) setFollow(w http.ResponseWriter, r *http.Request, apply func(ctx context.Context, c redis.Cmdable, userID, targetID int64) error) {
+	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
+	if err != nil {
+		log.Println("unauthorized:", err)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	username := strings.Trim(mux.Vars(r)["username"], "@")
+	target, err := manager.GetUserByName(r.Context(), a.client, username)
+	if err != nil {
+		logErr(w, "GetUserByName err:", err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if err := apply(r.Context(), a.client, self.ID(), target.ID()); err != nil {
+		logErr(w, "follow err:", err)
+	}
+	http.Redirect(w, r, fmt.Sprint("/@", username), http.StatusFound)
+}
+
+func (a *App) permalink(w http.ResponseWriter, r *http.Request) {
+	self, err := manager.AuthSelf(r, a.session, a.client, UserIDSession)
+	if err != nil {
+		log.Println("unauthorized:", err)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	selfname, err := self.Username(r.Context())
+	if err != nil {
+		log.Println("unauthorized:", err)
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	vars := mux.Vars(r)
+	user, err := manager.GetUserByName(r.Context(), a.client, strings.Trim(vars["username"], "@"))
+	if err != nil {
+		logErr(w, "GetUserByName err:", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	postID, err := strconv.ParseInt(vars["id"], 10, 64)
+	if err != nil {
+		logErr(w, "ParseInt err:", err)
+		http.NotFound(w, r)
+		return
+	}
+
+	ps, err := managerstore.GetPost(r.Context(), a.objs, a.client, user.ID(), postID)
+	if err == manager.ErrPostNotFound {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		logErr(w, "GetPost err:", err)
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	p := tmpl.PostParams{
+		Title:   "Post",
+		Name:    fmt.Sprint("@", selfname),
+		Post:    ps,
+		IsOwner: user.ID() == self.ID(),
+	}
+	tmpl.Post(w, p)
+}
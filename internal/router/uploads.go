@@ -0,0 +1,178 @@
+package router
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gocs/pensive/internal/managerstore"
+	"github.com/gorilla/mux"
+)
+
+// the resumable uploads follow the core protocol of tus 1.0.0 with its creation, expiration, and termination extensions
+// see https://tus.io/protocols/resumable-upload
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,expiration,termination"
+	// tusChunkType is the content type of the PATCH requests
+	tusChunkType = "application/offset+octet-stream"
+)
+
+// tusHeaders sets the headers every tus response carries
+func tusHeaders(w http.ResponseWriter) {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// tusVersionOK answers the requests of the clients of another protocol version
+func tusVersionOK(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Tus-Resumable") == tusVersion {
+		return true
+	}
+	w.Header().Set("Tus-Version", tusVersion)
+	writeJSON(w, http.StatusPreconditionFailed, apiError{Status: http.StatusPreconditionFailed, Message: "unsupported tus version"})
+	return false
+}
+
+// uploadHeaders describes the upload in the response
+func uploadHeaders(w http.ResponseWriter, up managerstore.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(up.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(up.Length, 10))
+	w.Header().Set("Upload-Expires", up.ExpiresAt.Format(http.TimeFormat))
+}
+
+// parseUploadMetadata reads the "key base64value,key base64value" pairs of the Upload-Metadata header
+func parseUploadMetadata(header string) map[string]string {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		meta[key] = string(b)
+	}
+	return meta
+}
+
+// maxMediaSize is the largest size the upload policy allows for any category
+func (a *App) maxMediaSize() int64 {
+	limit := int64(0)
+	for _, size := range a.media.MaxSize {
+		limit = max(limit, size)
+	}
+	return limit
+}
+
+// apiUploadOptions tells the clients what the server supports
+func (a *App) apiUploadOptions(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(a.maxMediaSize(), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiCreateUpload starts an upload of Upload-Length bytes, the filename is read from the Upload-Metadata
+func (a *App) apiCreateUpload(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	if !tusVersionOK(w, r) {
+		return
+	}
+	self, ok := a.apiAuth(w, r)
+	if !ok {
+		return
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Status: http.StatusBadRequest, Message: "Upload-Length is required"})
+		return
+	}
+	meta := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+
+	up, err := managerstore.CreateUpload(r.Context(), a.objs, a.client, a.media, a.quota, self.ID(), meta["filename"], length)
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	uploadHeaders(w, up)
+	w.Header().Set("Location", "/api/v1/uploads/"+up.ID)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// apiHeadUpload tells where the upload stopped so the client can resume from there
+func (a *App) apiHeadUpload(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	if !tusVersionOK(w, r) {
+		return
+	}
+	self, ok := a.apiAuth(w, r)
+	if !ok {
+		return
+	}
+
+	up, err := managerstore.GetUpload(r.Context(), a.client, self.ID(), mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	uploadHeaders(w, up)
+	w.WriteHeader(http.StatusOK)
+}
+
+// apiPatchUpload appends the body to the upload, Upload-Offset must be where the upload stopped
+func (a *App) apiPatchUpload(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	if !tusVersionOK(w, r) {
+		return
+	}
+	self, ok := a.apiAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Header.Get("Content-Type") != tusChunkType {
+		writeJSON(w, http.StatusUnsupportedMediaType, apiError{Status: http.StatusUnsupportedMediaType, Message: "Content-Type must be " + tusChunkType})
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeJSON(w, http.StatusBadRequest, apiError{Status: http.StatusBadRequest, Message: "Upload-Offset is required"})
+		return
+	}
+
+	// what was received must be kept even when the connection drops and cancels the request context
+	// the client then resumes from the offset it gets with HEAD
+	ctx := context.WithoutCancel(r.Context())
+	up, err := managerstore.WriteUpload(ctx, a.objs, a.client, a.bucket, a.media, a.quota, self.ID(), mux.Vars(r)["id"], offset, r.Body)
+	if err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	uploadHeaders(w, up)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiDeleteUpload drops the upload
+func (a *App) apiDeleteUpload(w http.ResponseWriter, r *http.Request) {
+	tusHeaders(w)
+	if !tusVersionOK(w, r) {
+		return
+	}
+	self, ok := a.apiAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if err := managerstore.AbortUpload(r.Context(), a.objs, a.client, a.bucket, self.ID(), mux.Vars(r)["id"]); err != nil {
+		writeJSONErr(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
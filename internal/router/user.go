@@ -2,8 +2,13 @@ package router
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/gocs/errored"
 	"github.com/gocs/pensive/internal/manager"
@@ -11,10 +16,10 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"github.com/gocs/pensive/pkg/mail"
-	"github.com/gocs/pensive/pkg/objectstore"
 	"github.com/gocs/pensive/pkg/token"
 	"github.com/gocs/pensive/pkg/validator"
 	"github.com/gocs/pensive/tmpl"
+	"github.com/gorilla/mux"
 )
 
 // UserLogin specific handler group for user interactions
@@ -54,8 +59,9 @@ func (u *UserLogin) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := u.session.Set(w, r, UserIDSession, user.ID()); err != nil {
-		logErr(w, "Set err:", err)
+	// a new session id is issued so one fixed before the login is not logged in
+	if err := u.session.Renew(w, r, UserIDSession, user.ID()); err != nil {
+		logErr(w, "Renew err:", err)
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
@@ -68,7 +74,6 @@ func (u *UserLogin) Post(w http.ResponseWriter, r *http.Request) {
 type UserRegister struct {
 	client  redis.Cmdable
 	session *sessions.Session
-	objs    *objectstore.ObjectStore
 }
 
 // Get should always redirect to "/" if the user is logged in otherwise go back to "/login" to relogin
@@ -107,16 +112,6 @@ func (ur *UserRegister) Post(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	location := "us-east-1"
-	opts := objectstore.MakeBucketOptions{Region: location}
-
-	err = ur.objs.MakeBucket(r.Context(), username, opts)
-	if err != nil {
-		logErr(w, "MakeBucket err:", err)
-		http.Redirect(w, r, "/register", http.StatusFound)
-		return
-	}
-
 	// send email verification and go back to login
 	// http.Redirect(w, r, "/verify", http.StatusFound)
 	http.Redirect(w, r, "/login", http.StatusFound)
@@ -129,8 +124,8 @@ func (a *App) userLogout(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := a.session.UnSet(w, r, UserIDSession); err != nil {
-		logErr(w, "UnSet err:", err)
+	if err := a.session.Destroy(w, r); err != nil {
+		logErr(w, "Destroy err:", err)
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
@@ -140,15 +135,18 @@ func (a *App) userLogout(w http.ResponseWriter, r *http.Request) {
 
 // UserSettings this is created so that the error field has few accessors; not that unique to App
 type UserSettings struct {
-	client       redis.Cmdable
-	session      *sessions.Session
-	fromEmail    string
-	password     string
-	accessSecret string
+	client  redis.Cmdable
+	session *sessions.Session
+	mailer  mail.Mailer
+	tokens  *token.Issuer
+	// quota is the default storage quota of the users
+	quota manager.Quota
+	// baseURL prefixes the links in the mails
+	baseURL string
 }
 
 func (us *UserSettings) Get(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -169,7 +167,7 @@ func (us *UserSettings) Get(w http.ResponseWriter, r *http.Request) {
 }
 
 func (us *UserSettings) GetProfile(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -181,16 +179,41 @@ func (us *UserSettings) GetProfile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	requiresAlt, err := user.RequiresAlt(r.Context())
+	if err != nil {
+		logErr(w, "RequiresAlt err:", err)
+		return
+	}
+
 	p := tmpl.ProfileParams{
-		Title: "Profile",
-		Name:  fmt.Sprint("@", u.Username),
-		User:  user,
+		Title:       "Profile",
+		Name:        fmt.Sprint("@", u.Username),
+		User:        user,
+		RequiresAlt: requiresAlt,
 	}
 	tmpl.Profile(w, p)
 }
 
+// SetPosting changes the preferences used when the user posts
+func (us *UserSettings) SetPosting(w http.ResponseWriter, r *http.Request) {
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	r.ParseForm()
+	requireAlt := r.PostForm.Get("require_alt") != ""
+
+	if err := user.RequireAlt(r.Context(), requireAlt); err != nil {
+		logErr(w, "RequireAlt err:", err)
+	}
+
+	http.Redirect(w, r, "/settings/profile", http.StatusFound)
+}
+
 func (us *UserSettings) SetProfile(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -214,7 +237,7 @@ func (us *UserSettings) SetProfile(w http.ResponseWriter, r *http.Request) {
 }
 
 func (us *UserSettings) GetPrivacy(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -226,16 +249,27 @@ func (us *UserSettings) GetPrivacy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var ss []sessions.Info
+	if us.session.CanList() {
+		ss, err = us.session.List(r, user.ID())
+		if err != nil {
+			logErr(w, "List err:", err)
+			return
+		}
+	}
+
 	p := tmpl.PrivacyParams{
-		Title: "Privacy",
-		Name:  fmt.Sprint("@", u.Username),
-		User:  user,
+		Title:           "Privacy",
+		Name:            fmt.Sprint("@", u.Username),
+		User:            user,
+		CanListSessions: us.session.CanList(),
+		Sessions:        ss,
 	}
 	tmpl.Privacy(w, p)
 }
 
 func (us *UserSettings) SetPrivacy(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -269,13 +303,50 @@ func (us *UserSettings) SetPrivacy(w http.ResponseWriter, r *http.Request) {
 
 	if err := manager.UpdatePassword(r.Context(), us.client, user.ID(), oldpassword, newpassword); err != nil {
 		logErr(w, "GetUser err:", err)
+		http.Redirect(w, r, "/settings/privacy", http.StatusFound)
+		return
+	}
+
+	// the password changed so every other device must login again
+	if err := us.session.RevokeOthers(r, user.ID()); err != nil {
+		logErr(w, "RevokeOthers err:", err)
+	}
+
+	http.Redirect(w, r, "/settings/privacy", http.StatusFound)
+}
+
+func (us *UserSettings) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	if err := us.session.Revoke(r, user.ID(), mux.Vars(r)["id"]); err != nil {
+		logErr(w, "Revoke err:", err)
 	}
 
 	http.Redirect(w, r, "/settings/privacy", http.StatusFound)
 }
 
+func (us *UserSettings) RevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	if err := us.session.RevokeAll(r, user.ID()); err != nil {
+		logErr(w, "RevokeAll err:", err)
+		http.Redirect(w, r, "/settings/privacy", http.StatusFound)
+		return
+	}
+
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
 func (us *UserSettings) GetAccount(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -293,17 +364,54 @@ func (us *UserSettings) GetAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	pendingEmail, err := user.PendingEmail(r.Context())
+	if err != nil {
+		logErr(w, "PendingEmail err:", err)
+		return
+	}
+
+	storage, err := storageParams(r.Context(), us.client, user.ID(), us.quota)
+	if err != nil {
+		logErr(w, "storageParams err:", err)
+		return
+	}
+
 	p := tmpl.AccountParams{
-		Title:      "Account",
-		Name:       fmt.Sprint("@", u.Username),
-		User:       user,
-		IsVerified: isVerified,
+		Title:        "Account",
+		Name:         fmt.Sprint("@", u.Username),
+		User:         user,
+		IsVerified:   isVerified,
+		PendingEmail: pendingEmail,
+		Storage:      storage,
 	}
 	tmpl.Account(w, p)
 }
 
+// storageParams describes the storage used by the user against their quota
+func storageParams(ctx context.Context, c redis.Cmdable, userID int64, def manager.Quota) (tmpl.StorageParams, error) {
+	q, err := manager.GetQuota(ctx, c, userID, def)
+	if err != nil {
+		return tmpl.StorageParams{}, err
+	}
+	u, err := manager.GetUsage(ctx, c, userID)
+	if err != nil {
+		return tmpl.StorageParams{}, err
+	}
+
+	p := tmpl.StorageParams{Used: manager.FormatBytes(u.Bytes), Objects: u.Objects, ObjectLimit: q.Objects}
+	if q.Bytes > 0 {
+		p.Limit = manager.FormatBytes(q.Bytes)
+		p.Percent = int(u.Bytes * 100 / q.Bytes)
+	}
+	if q.Objects > 0 {
+		p.Percent = max(p.Percent, int(u.Objects*100/q.Objects))
+	}
+	p.Percent = min(p.Percent, 100)
+	return p, nil
+}
+
 func (us *UserSettings) SetAccount(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
@@ -319,49 +427,70 @@ func (us *UserSettings) SetAccount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := validator.IsEmail(email); err != nil {
+		logErr(w, "IsEmail err:", err)
+		http.Redirect(w, r, "/settings/account", http.StatusFound)
+		return
+	}
+
 	if err := manager.UpdateEmail(r.Context(), us.client, user.ID(), email, password); err != nil {
-		logErr(w, "GetUser err:", err)
+		logErr(w, "UpdateEmail err:", err)
+		http.Redirect(w, r, "/settings/account", http.StatusFound)
+		return
+	}
+
+	if err := sendEmailChange(r.Context(), us.mailer, us.tokens, us.baseURL, us.client, user); err != nil {
+		logErr(w, "sendEmailChange err:", err)
 	}
 
 	http.Redirect(w, r, "/settings/account", http.StatusFound)
 }
 
-func (us *UserSettings) VerifyEmail(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+// pendingEmailBinding is the fingerprint of the pending email of the user
+// email change tokens are bound to it so only the link for the latest request works, and only once
+func pendingEmailBinding(ctx context.Context, c redis.Cmdable, userID string) (string, error) {
+	id, err := strconv.ParseInt(userID, 10, 64)
 	if err != nil {
-		logErr(w, "AuthSelf err:", err)
-		http.Redirect(w, r, r.Referer(), http.StatusFound)
-		return
+		return "", err
 	}
 
-	r.URL.Scheme = "http"
-	if r.TLS != nil {
-		r.URL.Scheme = "https"
+	user, err := manager.GetUserByUserID(c, id)
+	if err != nil {
+		return "", err
 	}
-	fullHost := fmt.Sprintf("%s://%s", r.URL.Scheme, r.Host)
 
-	err = sendVerification(r.Context(), us.accessSecret, us.fromEmail, us.password, fullHost, user)
+	pending, err := user.PendingEmail(ctx)
 	if err != nil {
-		logErr(w, "oldpassword, newpassword, or confpassword cannot be empty")
-		http.Redirect(w, r, "/settings/account", http.StatusFound)
-		return
+		return "", err
 	}
+	if pending == "" {
+		return "", manager.ErrNoPendingEmail
+	}
+	return fingerprint([]byte(pending)), nil
+}
 
-	http.Redirect(w, r, r.Referer(), http.StatusFound)
+// fingerprint is what tokens are bound to instead of the raw user state
+func fingerprint(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
 }
 
-func sendVerification(ctx context.Context, accessSecret, fromEmail, password, appIP string, user *manager.User) error {
-	t, err := token.Create(accessSecret, fmt.Sprint(user.ID()))
+// sendEmailChange sends the confirmation link to the pending email and a notice to the current one
+func sendEmailChange(ctx context.Context, mailer mail.Mailer, tokens *token.Issuer, appIP string, c redis.Cmdable, user *manager.User) error {
+	userID := fmt.Sprint(user.ID())
+	binding, err := pendingEmailBinding(ctx, c, userID)
 	if err != nil {
 		return err
 	}
 
-	isVerified, err := user.IsVerified(ctx)
+	t, err := tokens.Issue(ctx, token.PurposeEmailChange, userID, binding)
 	if err != nil {
 		return err
 	}
-	if isVerified {
-		return errored.New("user is already verified")
+
+	username, err := user.Username(ctx)
+	if err != nil {
+		return err
 	}
 
 	email, err := user.Email(ctx)
@@ -369,49 +498,142 @@ func sendVerification(ctx context.Context, accessSecret, fromEmail, password, ap
 		return err
 	}
 
-	username, err := user.Username(ctx)
+	pending, err := user.PendingEmail(ctx)
 	if err != nil {
 		return err
 	}
 
-	builtToken := fmt.Sprintf("%s/verify?token=%s", appIP, t)
+	builtToken := fmt.Sprintf("%s/settings/account/confirm?token=%s", appIP, url.QueryEscape(t))
 
-	body := verifyMailBody(username, builtToken)
-	err = mail.Send(fromEmail, password, email, defaultSubject, body)
+	text, html, err := tmpl.EmailChangeMail(tmpl.EmailChangeMailParams{
+		Username: username,
+		Link:     builtToken,
+		Expires:  humanDuration(tokens.TTL(token.PurposeEmailChange)),
+	})
 	if err != nil {
 		return err
 	}
-	return nil
+	msg := mail.Message{
+		To:      pending,
+		ToName:  fmt.Sprint("@", username),
+		Subject: emailChangeSubject,
+		Text:    text,
+		HTML:    html,
+	}
+	if err := mailer.Send(ctx, msg); err != nil {
+		return err
+	}
+
+	text, html, err = tmpl.EmailNoticeMail(tmpl.EmailNoticeMailParams{Username: username, NewEmail: pending})
+	if err != nil {
+		return err
+	}
+	msg = mail.Message{
+		To:      email,
+		ToName:  fmt.Sprint("@", username),
+		Subject: emailNoticeSubject,
+		Text:    text,
+		HTML:    html,
+	}
+	return mailer.Send(ctx, msg)
 }
 
-const defaultSubject = "Welcome to pensive"
-const verifyMailTmpl = `
-Welcome to pensive
+const emailChangeSubject = "Confirm your new pensive e-mail"
+const emailNoticeSubject = "Your pensive e-mail is being changed"
+
+// ConfirmEmailChange applies the email change when the link sent to the new email is clicked
+// the token alone proves the ownership of the new email so this does not need a session
+func (us *UserSettings) ConfirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	t := r.URL.Query().Get("token")
+
+	ctx := r.Context()
+	userID, err := us.tokens.Consume(ctx, token.PurposeEmailChange, t, func(userID string) (string, error) {
+		return pendingEmailBinding(ctx, us.client, userID)
+	})
+	if err != nil {
+		logErr(w, "token Consume err:", err)
+		http.Redirect(w, r, "/settings/account", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		logErr(w, "ParseInt err:", err)
+		http.Redirect(w, r, "/settings/account", http.StatusFound)
+		return
+	}
+
+	if err := manager.ConfirmEmail(ctx, us.client, id); err != nil {
+		logErr(w, "ConfirmEmail err:", err)
+	}
+
+	http.Redirect(w, r, "/settings/account", http.StatusFound)
+}
+
+func (us *UserSettings) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
+	if err != nil {
+		logErr(w, "AuthSelf err:", err)
+		http.Redirect(w, r, r.Referer(), http.StatusFound)
+		return
+	}
 
+	err = sendVerification(r.Context(), us.mailer, us.tokens, us.baseURL, user)
+	if err != nil {
+		logErr(w, "oldpassword, newpassword, or confpassword cannot be empty")
+		http.Redirect(w, r, "/settings/account", http.StatusFound)
+		return
+	}
 
-Your account @%s, is successfully created. 
-We graciously welcome you at our place.
-In order to access you with our best possible service, please verify your e-mail by clicking the link below:
+	http.Redirect(w, r, r.Referer(), http.StatusFound)
+}
 
-%s
+func sendVerification(ctx context.Context, mailer mail.Mailer, tokens *token.Issuer, appIP string, user *manager.User) error {
+	isVerified, err := user.IsVerified(ctx)
+	if err != nil {
+		return err
+	}
+	if isVerified {
+		return errored.New("user is already verified")
+	}
 
-If this is not your account, unexpected behavior, or you would not want to verify your e-mail for pensive, DO NOT CLICK.
-Perhaps, report the incident to us.
+	email, err := user.Email(ctx)
+	if err != nil {
+		return err
+	}
 
-Thank you for being with us. Enjoy your stay.
+	// bound to the email so the link stops working once the email changes
+	t, err := tokens.Issue(ctx, token.PurposeEmailVerify, fmt.Sprint(user.ID()), fingerprint([]byte(email)))
+	if err != nil {
+		return err
+	}
 
+	username, err := user.Username(ctx)
+	if err != nil {
+		return err
+	}
 
+	builtToken := fmt.Sprintf("%s/verify?token=%s", appIP, url.QueryEscape(t))
 
---
-DO NOT REPLY
-`
+	text, html, err := tmpl.VerifyMail(tmpl.VerifyMailParams{Username: username, Link: builtToken})
+	if err != nil {
+		return err
+	}
 
-func verifyMailBody(username, link string) string {
-	return fmt.Sprintf(verifyMailTmpl, username, link)
+	msg := mail.Message{
+		To:      email,
+		ToName:  fmt.Sprint("@", username),
+		Subject: defaultSubject,
+		Text:    text,
+		HTML:    html,
+	}
+	return mailer.Send(ctx, msg)
 }
 
+const defaultSubject = "Welcome to pensive"
+
 func (us *UserSettings) AcceptEmailVerif(w http.ResponseWriter, r *http.Request) {
-	user, err := manager.AuthSelf(r, us.session, us.client, UserIDSession)
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
 	if err != nil {
 		logErr(w, "AuthSelf err:", err)
 		http.Redirect(w, r, r.Referer(), http.StatusFound)
@@ -433,8 +655,19 @@ func (us *UserSettings) AcceptEmailVerif(w http.ResponseWriter, r *http.Request)
 	v := r.URL.Query()
 	t := v.Get("token")
 
-	if err := token.Verify(us.accessSecret, t, fmt.Sprint(user.ID())); err != nil {
-		logErr(w, "token Verify err:", err)
+	ctx := r.Context()
+	_, err = us.tokens.Consume(ctx, token.PurposeEmailVerify, t, func(userID string) (string, error) {
+		if userID != fmt.Sprint(user.ID()) {
+			return "", errored.New("claims doesn't match the expected user")
+		}
+		email, err := user.Email(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fingerprint([]byte(email)), nil
+	})
+	if err != nil {
+		logErr(w, "token Consume err:", err)
 		http.Redirect(w, r, r.Referer(), http.StatusFound)
 		return
 	}
@@ -449,3 +682,255 @@ func (us *UserSettings) AcceptEmailVerif(w http.ResponseWriter, r *http.Request)
 
 	http.Redirect(w, r, r.Referer(), http.StatusFound)
 }
+
+func (us *UserSettings) GetTokens(w http.ResponseWriter, r *http.Request) {
+	us.renderTokens(w, r, "")
+}
+
+// renderTokens shows the tokens page, newToken is the freshly minted raw token shown only once
+func (us *UserSettings) renderTokens(w http.ResponseWriter, r *http.Request, newToken string) {
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	u, err := manager.GetUser(r.Context(), us.client, user)
+	if err != nil {
+		logErr(w, "GetUser err:", err)
+		return
+	}
+
+	tokens, err := manager.ListAPITokens(r.Context(), us.client, user.ID())
+	if err != nil {
+		logErr(w, "ListAPITokens err:", err)
+		return
+	}
+
+	p := tmpl.TokensParams{
+		Title:    "Tokens",
+		Name:     fmt.Sprint("@", u.Username),
+		User:     user,
+		Tokens:   tokens,
+		NewToken: newToken,
+	}
+	tmpl.Tokens(w, p)
+}
+
+func (us *UserSettings) CreateToken(w http.ResponseWriter, r *http.Request) {
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	r.ParseForm()
+	name := r.PostForm.Get("name")
+	scopes := r.PostForm["scopes"]
+
+	raw, err := manager.CreateAPIToken(r.Context(), us.client, user.ID(), name, scopes)
+	if err != nil {
+		logErr(w, "CreateAPIToken err:", err)
+		http.Redirect(w, r, "/settings/tokens", http.StatusFound)
+		return
+	}
+
+	// the raw token is not stored so it is rendered right away instead of redirecting
+	w.Header().Set("Cache-Control", "no-store")
+	us.renderTokens(w, r, raw)
+}
+
+func (us *UserSettings) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	user, err := manager.AuthSession(r, us.session, us.client, UserIDSession)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+
+	tokenID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		logErr(w, "ParseInt err:", err)
+		http.Redirect(w, r, "/settings/tokens", http.StatusFound)
+		return
+	}
+
+	if err := manager.RevokeAPIToken(r.Context(), us.client, user.ID(), tokenID); err != nil {
+		logErr(w, "RevokeAPIToken err:", err)
+	}
+	http.Redirect(w, r, "/settings/tokens", http.StatusFound)
+}
+
+// UserReset handles the forgotten password flow of users that cannot login
+type UserReset struct {
+	client  redis.Cmdable
+	session *sessions.Session
+	mailer  mail.Mailer
+	tokens  *token.Issuer
+	// baseURL prefixes the links in the mails
+	baseURL string
+}
+
+// passwordBinding is the fingerprint of the current password hash of the user
+// reset tokens are bound to it so they stop working once the password changes
+func passwordBinding(ctx context.Context, c redis.Cmdable, userID string) (string, error) {
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		return "", err
+	}
+
+	user, err := manager.GetUserByUserID(c, id)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := user.Password(ctx)
+	if err != nil {
+		return "", err
+	}
+	return fingerprint(hash), nil
+}
+
+func (ur *UserReset) GetForgot(w http.ResponseWriter, r *http.Request) {
+	p := tmpl.ForgotParams{Sent: r.URL.Query().Get("sent") != ""}
+	tmpl.Forgot(w, p)
+}
+
+// PostForgot always reports that the mail is sent so it does not tell which usernames exist
+func (ur *UserReset) PostForgot(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	username := r.PostForm.Get("username")
+
+	if err := ur.sendReset(r.Context(), ur.baseURL, username); err != nil {
+		logErr(w, "sendReset err:", err)
+	}
+
+	http.Redirect(w, r, "/forgot?sent=1", http.StatusFound)
+}
+
+func (ur *UserReset) sendReset(ctx context.Context, appIP, username string) error {
+	user, err := manager.GetUserByName(ctx, ur.client, username)
+	if err != nil {
+		return err
+	}
+
+	userID := fmt.Sprint(user.ID())
+	binding, err := passwordBinding(ctx, ur.client, userID)
+	if err != nil {
+		return err
+	}
+
+	t, err := ur.tokens.Issue(ctx, token.PurposePasswordReset, userID, binding)
+	if err != nil {
+		return err
+	}
+
+	email, err := user.Email(ctx)
+	if err != nil {
+		return err
+	}
+
+	builtToken := fmt.Sprintf("%s/reset?token=%s", appIP, url.QueryEscape(t))
+
+	text, html, err := tmpl.ResetMail(tmpl.ResetMailParams{
+		Username: username,
+		Link:     builtToken,
+		Expires:  humanDuration(ur.tokens.TTL(token.PurposePasswordReset)),
+	})
+	if err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      email,
+		ToName:  fmt.Sprint("@", username),
+		Subject: resetSubject,
+		Text:    text,
+		HTML:    html,
+	}
+	return ur.mailer.Send(ctx, msg)
+}
+
+const resetSubject = "Reset your pensive password"
+
+func (ur *UserReset) GetReset(w http.ResponseWriter, r *http.Request) {
+	t := r.URL.Query().Get("token")
+
+	// only checked here, the token is consumed when the new password is submitted
+	ctx := r.Context()
+	if _, err := ur.tokens.Check(ctx, token.PurposePasswordReset, t, func(userID string) (string, error) {
+		return passwordBinding(ctx, ur.client, userID)
+	}); err != nil {
+		logErr(w, "token Check err:", err)
+		http.Redirect(w, r, "/forgot", http.StatusFound)
+		return
+	}
+
+	p := tmpl.ResetParams{Token: t}
+	tmpl.Reset(w, p)
+}
+
+func (ur *UserReset) PostReset(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	t := r.PostForm.Get("token")
+	newpassword := r.PostForm.Get("new_password")   // dont save raw password string to session
+	confpassword := r.PostForm.Get("conf_password") // dont save raw password string to session
+
+	back := fmt.Sprint("/reset?token=", url.QueryEscape(t))
+	if newpassword == "" || newpassword != confpassword {
+		logErr(w, "new password is empty or does not match its confirmation")
+		http.Redirect(w, r, back, http.StatusFound)
+		return
+	}
+
+	// the password is checked before the token is consumed so a rejected one does not burn the link
+	hash, err := manager.HashPassword(newpassword)
+	if err != nil {
+		logErr(w, "HashPassword err:", err)
+		http.Redirect(w, r, back, http.StatusFound)
+		return
+	}
+
+	ctx := r.Context()
+	userID, err := ur.tokens.Consume(ctx, token.PurposePasswordReset, t, func(userID string) (string, error) {
+		return passwordBinding(ctx, ur.client, userID)
+	})
+	if err != nil {
+		logErr(w, "token Consume err:", err)
+		http.Redirect(w, r, "/forgot", http.StatusFound)
+		return
+	}
+
+	id, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		logErr(w, "ParseInt err:", err)
+		http.Redirect(w, r, "/forgot", http.StatusFound)
+		return
+	}
+
+	// the new hash also invalidates the other reset tokens since they are bound to the old one
+	if err := manager.ResetPassword(ctx, ur.client, id, hash); err != nil {
+		logErr(w, "ResetPassword err:", err)
+		http.Redirect(w, r, back, http.StatusFound)
+		return
+	}
+
+	if err := ur.session.RevokeAll(r, id); err != nil && err != sessions.ErrNotSupported {
+		logErr(w, "RevokeAll err:", err)
+	}
+
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+// humanDuration writes how long a link is valid for the mails, e.g. "30 minutes" or "24 hours"
+func humanDuration(d time.Duration) string {
+	plural := func(n int64, unit string) string {
+		if n == 1 {
+			return fmt.Sprint(n, " ", unit)
+		}
+		return fmt.Sprint(n, " ", unit, "s")
+	}
+	if d >= time.Hour && d%time.Hour == 0 {
+		return plural(int64(d/time.Hour), "hour")
+	}
+	return plural(int64(d.Round(time.Minute)/time.Minute), "minute")
+}
@@ -0,0 +1,357 @@
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gocs/pensive/pkg/timelayout"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultTTL is how long an idle session is kept in redis when the ttl is unset
+const DefaultTTL = 30 * 24 * time.Hour
+
+// Info describes a stored session of a user
+type Info struct {
+	ID        string // public handle of the session; the real session id is never exposed
+	UserAgent string
+	IP        string
+	CreatedAt *time.Time
+	LastSeen  *time.Time
+	Current   bool // the session used by the request listing the sessions
+}
+
+// RedisStore keeps the session values in redis while the cookie only carries the signed session id
+// sessions are indexed by the user id found in the indexKey value so they can be listed and revoked
+type RedisStore struct {
+	c        redis.Cmdable
+	codecs   []securecookie.Codec
+	serial   securecookie.GobEncoder
+	ttl      time.Duration
+	indexKey string
+	proxies  []*net.IPNet
+	Options  *sessions.Options // default configuration
+}
+
+// NewRedisStore creates a session store backed by redis
+// indexKey is the session value key that holds the user id, e.g. "user_id"
+// the client address is read from the proxy headers only when the request comes from one of the proxies
+func NewRedisStore(c redis.Cmdable, secret string, ttl time.Duration, indexKey string, proxies []*net.IPNet) *RedisStore {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &RedisStore{
+		c:        c,
+		codecs:   securecookie.CodecsFromPairs([]byte(secret)),
+		ttl:      ttl,
+		indexKey: indexKey,
+		proxies:  proxies,
+		Options: &sessions.Options{
+			Path:     "/",
+			MaxAge:   int(ttl.Seconds()),
+			HttpOnly: true,
+			// the state changing forms have no csrf token, the cookie is not sent with the posts of other sites
+			SameSite: http.SameSiteLaxMode,
+		},
+	}
+}
+
+func sessionKey(id string) string         { return "session:" + id }
+func userSessionsKey(userID int64) string { return fmt.Sprintf("user:%d:sessions", userID) }
+
+// handle is the public id of a session shown to the user
+func handle(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Get returns the session registered for the request, see sessions.CookieStore.Get
+func (s *RedisStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New loads the session pointed by the cookie, a revoked or expired session becomes a new one
+func (s *RedisStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+
+	var id string
+	if err := securecookie.DecodeMulti(name, c.Value, &id, s.codecs...); err != nil {
+		return session, err
+	}
+
+	ctx := r.Context()
+	fields, err := s.c.HMGet(ctx, sessionKey(id), "values", "last_seen").Result()
+	if err != nil {
+		return session, err
+	}
+	data, ok := fields[0].(string)
+	if !ok {
+		return session, nil
+	}
+	if err := s.serial.Deserialize([]byte(data), &session.Values); err != nil {
+		return session, err
+	}
+	session.ID = id
+	session.IsNew = false
+
+	// refresh the idle timeout and what the user is currently using, at most once per lastSeenInterval
+	lastSeen, _ := fields[1].(string)
+	if t, ok := parseTime(lastSeen); ok && time.Since(t) < lastSeenInterval {
+		return session, nil
+	}
+	pipe := s.c.Pipeline()
+	pipe.HSet(ctx, sessionKey(id), "last_seen", time.Now().UTC().Format(time.RFC3339), "user_agent", r.UserAgent(), "ip", s.clientIP(r))
+	pipe.Expire(ctx, sessionKey(id), s.ttl)
+	if userID, indexed := session.Values[s.indexKey].(int64); indexed {
+		pipe.Expire(ctx, userSessionsKey(userID), s.ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return session, err
+}
+
+// lastSeenInterval is how often the last use of a session is recorded
+const lastSeenInterval = time.Minute
+
+// parseTime reads the dates of a session, the oldest sessions wrote them with time.Time.String
+func parseTime(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	t, err := time.Parse(timelayout.UTCLayout, s)
+	return t, err == nil
+}
+
+// Save stores the session values in redis and sets the cookie; MaxAge < 0 deletes the session
+func (s *RedisStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	ctx := r.Context()
+	if session.Options.MaxAge < 0 {
+		if session.ID != "" {
+			if err := s.delete(ctx, session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		session.ID = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	data, err := s.serial.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+
+	// set created at date
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	key := sessionKey(session.ID)
+	previous, err := s.c.HGet(ctx, key, "user_id").Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+	userID, indexed := session.Values[s.indexKey].(int64)
+
+	pipe := s.c.Pipeline()
+	pipe.HSet(ctx, key, "values", data)
+	pipe.HSetNX(ctx, key, "created_at", now)
+	pipe.HSet(ctx, key, "last_seen", now)
+	pipe.HSet(ctx, key, "user_agent", r.UserAgent())
+	pipe.HSet(ctx, key, "ip", s.clientIP(r))
+	if previous != 0 && previous != userID {
+		pipe.SRem(ctx, userSessionsKey(previous), session.ID)
+		pipe.HDel(ctx, key, "user_id")
+	}
+	if indexed {
+		pipe.HSet(ctx, key, "user_id", userID)
+		pipe.SAdd(ctx, userSessionsKey(userID), session.ID)
+		// the index outlives the sessions in it by at most the ttl, the expired ids are dropped by List
+		pipe.Expire(ctx, userSessionsKey(userID), s.ttl)
+	}
+	pipe.Expire(ctx, key, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *RedisStore) delete(ctx context.Context, id string) error {
+	userID, err := s.c.HGet(ctx, sessionKey(id), "user_id").Int64()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	pipe := s.c.Pipeline()
+	pipe.Del(ctx, sessionKey(id))
+	if userID != 0 {
+		pipe.SRem(ctx, userSessionsKey(userID), id)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// List lists the live sessions of the user, currentID marks the session of the request
+func (s *RedisStore) List(ctx context.Context, userID int64, currentID string) ([]Info, error) {
+	ids, err := s.c.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []Info{}
+	for _, id := range ids {
+		fields, err := s.c.HGetAll(ctx, sessionKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		// the session has expired, drop it from the index
+		if len(fields) == 0 {
+			if err := s.c.SRem(ctx, userSessionsKey(userID), id).Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		info := Info{
+			ID:        handle(id),
+			UserAgent: fields["user_agent"],
+			IP:        fields["ip"],
+			Current:   id == currentID,
+		}
+		if t, ok := parseTime(fields["created_at"]); ok {
+			info.CreatedAt = &t
+		}
+		if t, ok := parseTime(fields["last_seen"]); ok {
+			info.LastSeen = &t
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Revoke deletes the session of the user with the public handle
+func (s *RedisStore) Revoke(ctx context.Context, userID int64, handleID string) error {
+	ids, err := s.c.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if handle(id) == handleID {
+			return s.delete(ctx, id)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// RevokeAll deletes all sessions of the user except the session with keepID
+// keepID may be empty to delete every session
+func (s *RedisStore) RevokeAll(ctx context.Context, userID int64, keepID string) error {
+	ids, err := s.c.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == keepID {
+			continue
+		}
+		if err := s.delete(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseProxies parses the addresses or CIDRs of the trusted reverse proxies, e.g. "10.0.0.1" or "172.16.0.0/12"
+func ParseProxies(list []string) ([]*net.IPNet, error) {
+	proxies := []*net.IPNet{}
+	for _, item := range list {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", item)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			item = fmt.Sprintf("%s/%d", item, bits)
+		}
+		_, ipnet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, ipnet)
+	}
+	return proxies, nil
+}
+
+// trusted tells if the address is one of the trusted proxies
+func (s *RedisStore) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range s.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP gets the address of the client
+// the headers set by the reverse proxies are only read when the request comes from a trusted one
+// since any client can send them
+func (s *RedisStore) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !s.trusted(ip) {
+		return ip
+	}
+
+	// each proxy appends the address it got the request from, the first untrusted one from the right is the client
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip = strings.TrimSpace(hops[i])
+			if !s.trusted(ip) {
+				return ip
+			}
+		}
+		return ip
+	}
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		return realIP
+	}
+	return ip
+}
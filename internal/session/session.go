@@ -2,26 +2,55 @@ package sessions
 
 import (
 	"errors"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/sessions"
+	"github.com/redis/go-redis/v9"
 )
 
 var (
 	// ErrUserNotLoggedIn give error when session does not account you of logging in
 	ErrUserNotLoggedIn = errors.New("you must login first")
+
+	// ErrSessionNotFound give error when the session to be revoked does not exist
+	ErrSessionNotFound = errors.New("session not found")
+
+	// ErrNotSupported give error when the session store cannot list or revoke sessions
+	ErrNotSupported = errors.New("session store does not support listing sessions")
 )
 
 // Session is used for storing session cookies
 type Session struct {
-	store       *sessions.CookieStore
+	store       sessions.Store
+	redis       *RedisStore // nil if the sessions are only kept in the cookie
 	sessionName string
 }
 
 // New creates new session using a secret key
-func New(secret, sessionName string) *Session {
+// secure limits the cookie to https, it is set when the app is reached over https
+func New(secret, sessionName string, secure bool) *Session {
+	cs := sessions.NewCookieStore([]byte(secret))
+	cs.Options.HttpOnly = true
+	cs.Options.Secure = secure
+	cs.Options.SameSite = http.SameSiteLaxMode
+	return &Session{
+		store:       cs,
+		sessionName: sessionName,
+	}
+}
+
+// NewRedis creates new session kept in redis using a secret key to sign the session id cookie
+// indexKey is the session value key that holds the user id the sessions are listed by
+// proxies are the reverse proxies whose headers tell the address of the client, see ParseProxies
+// secure limits the cookie to https, it is set when the app is reached over https
+func NewRedis(c redis.Cmdable, secret, sessionName string, ttl time.Duration, indexKey string, proxies []*net.IPNet, secure bool) *Session {
+	rs := NewRedisStore(c, secret, ttl, indexKey, proxies)
+	rs.Options.Secure = secure
 	return &Session{
-		store:       sessions.NewCookieStore([]byte(secret)),
+		store:       rs,
+		redis:       rs,
 		sessionName: sessionName,
 	}
 }
@@ -60,6 +89,24 @@ func (s *Session) Set(w http.ResponseWriter, r *http.Request, key string, value
 	return session.Save(r, w)
 }
 
+// Renew replaces the session of the request with a new one holding only the value by key
+// it is used on login so a session id planted before it cannot be used to ride the logged in session
+func (s *Session) Renew(w http.ResponseWriter, r *http.Request, key string, value interface{}) error {
+	session, err := s.store.Get(r, s.sessionName)
+	if err != nil {
+		return err
+	}
+	if s.redis != nil && session.ID != "" {
+		if err := s.redis.delete(r.Context(), session.ID); err != nil {
+			return err
+		}
+	}
+	// an empty id makes the redis store generate a new one when saving
+	session.ID, session.IsNew = "", true
+	session.Values = map[interface{}]interface{}{key: value}
+	return session.Save(r, w)
+}
+
 func (s *Session) UnSet(w http.ResponseWriter, r *http.Request, key string) error {
 	session, err := s.store.Get(r, s.sessionName)
 	if err != nil {
@@ -68,3 +115,58 @@ func (s *Session) UnSet(w http.ResponseWriter, r *http.Request, key string) erro
 	delete(session.Values, key)
 	return session.Save(r, w)
 }
+
+// Destroy deletes the whole session, and with the redis store also its stored values
+func (s *Session) Destroy(w http.ResponseWriter, r *http.Request) error {
+	session, err := s.store.Get(r, s.sessionName)
+	if err != nil {
+		return err
+	}
+	session.Options.MaxAge = -1
+	return session.Save(r, w)
+}
+
+// CanList reports if the sessions can be listed and revoked
+func (s *Session) CanList() bool { return s.redis != nil }
+
+// currentID gets the id of the session used by the request
+func (s *Session) currentID(r *http.Request) string {
+	session, err := s.store.Get(r, s.sessionName)
+	if err != nil {
+		return ""
+	}
+	return session.ID
+}
+
+// List lists the live sessions of the user
+func (s *Session) List(r *http.Request, userID int64) ([]Info, error) {
+	if s.redis == nil {
+		return nil, ErrNotSupported
+	}
+	return s.redis.List(r.Context(), userID, s.currentID(r))
+}
+
+// Revoke logs out the session of the user with the public id listed by List
+func (s *Session) Revoke(r *http.Request, userID int64, id string) error {
+	if s.redis == nil {
+		return ErrNotSupported
+	}
+	return s.redis.Revoke(r.Context(), userID, id)
+}
+
+// RevokeAll logs out every session of the user including the current one
+func (s *Session) RevokeAll(r *http.Request, userID int64) error {
+	if s.redis == nil {
+		return ErrNotSupported
+	}
+	return s.redis.RevokeAll(r.Context(), userID, "")
+}
+
+// RevokeOthers logs out every session of the user except the current one
+// this is a no-op for the cookie store since the other cookies cannot be reached
+func (s *Session) RevokeOthers(r *http.Request, userID int64) error {
+	if s.redis == nil {
+		return nil
+	}
+	return s.redis.RevokeAll(r.Context(), userID, s.currentID(r))
+}
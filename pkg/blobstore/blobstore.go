@@ -0,0 +1,136 @@
+// Package blobstore stores the media of the posts behind one interface
+// so the app can run on MinIO, SeaweedFS, or the local filesystem
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the bucket or the blob does not exist
+	ErrNotFound = errors.New("blob not found")
+	// ErrNotSupported is returned when the backend cannot do the operation, e.g. presigning on the filesystem
+	ErrNotSupported = errors.New("operation not supported by the blob store")
+	// ErrInvalidKey is returned for keys that could escape their bucket
+	ErrInvalidKey = errors.New("invalid blob key")
+	// ErrUnknownBackend is returned when the configured backend is not one of the stores of this package
+	ErrUnknownBackend = errors.New("unknown blob store backend")
+)
+
+// Info describes a stored blob
+type Info struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string // user metadata, keys are lowercase
+}
+
+// PutOptions describes a blob being stored
+type PutOptions struct {
+	ContentType string
+	Metadata    map[string]string // user metadata, keys are lowercase
+}
+
+// BlobStore keeps blobs under keys grouped in buckets
+// keys may contain "/" to group blobs further
+type BlobStore interface {
+	// MakeBucket creates the bucket if it does not exist yet
+	MakeBucket(ctx context.Context, bucket string) error
+	// Put stores the blob, size may be -1 when it is unknown
+	Put(ctx context.Context, bucket, key string, r io.Reader, size int64, opts PutOptions) (Info, error)
+	// Get reads length bytes of the blob from offset; a negative length reads until the end
+	Get(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error)
+	// Stat gets the description of the blob without reading it
+	Stat(ctx context.Context, bucket, key string) (Info, error)
+	// Delete removes the blob, removing a missing blob is not an error
+	Delete(ctx context.Context, bucket, key string) error
+	// List lists the blobs of the bucket whose key starts with prefix
+	List(ctx context.Context, bucket, prefix string) ([]Info, error)
+	// PresignGet makes a url that reads the blob without credentials until it expires
+	// params overrides the response headers, e.g. "response-content-type"
+	PresignGet(ctx context.Context, bucket, key string, expiry time.Duration, params url.Values) (*url.URL, error)
+}
+
+// cleanKey checks that the key is relative and does not climb out of its bucket
+func cleanKey(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	if path.Clean(key) != key || key == ".." || strings.HasPrefix(key, "../") {
+		return "", ErrInvalidKey
+	}
+	return key, nil
+}
+
+// ReadSeeker reads a blob of a known size through ranged gets so it can be given to http.ServeContent
+type ReadSeeker struct {
+	ctx    context.Context
+	store  BlobStore
+	bucket string
+	key    string
+	size   int64
+
+	offset int64
+	r      io.ReadCloser // reads from offset, opened on the first read after a seek
+}
+
+// NewReadSeeker makes a reader of the blob, size is the size from Stat
+func NewReadSeeker(ctx context.Context, store BlobStore, bucket, key string, size int64) *ReadSeeker {
+	return &ReadSeeker{ctx: ctx, store: store, bucket: bucket, key: key, size: size}
+}
+
+func (rs *ReadSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.r == nil {
+		r, err := rs.store.Get(rs.ctx, rs.bucket, rs.key, rs.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		rs.r = r
+	}
+	n, err := rs.r.Read(p)
+	rs.offset += int64(n)
+	return n, err
+}
+
+func (rs *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rs.offset
+	case io.SeekEnd:
+		offset += rs.size
+	default:
+		return 0, errors.New("blobstore: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("blobstore: negative position")
+	}
+
+	if offset != rs.offset && rs.r != nil {
+		rs.r.Close()
+		rs.r = nil
+	}
+	rs.offset = offset
+	return offset, nil
+}
+
+// Close closes the current ranged get
+func (rs *ReadSeeker) Close() error {
+	if rs.r == nil {
+		return nil
+	}
+	err := rs.r.Close()
+	rs.r = nil
+	return err
+}
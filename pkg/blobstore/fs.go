@@ -0,0 +1,350 @@
+package blobstore
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metaDir keeps the descriptions of the blobs apart from the buckets so it cannot clash with a key
+const metaDir = ".meta"
+
+// FS stores the blobs as files under a root directory, one directory per bucket
+// it is meant for development and tests, it cannot presign urls
+type FS struct {
+	root string
+}
+
+// NewFS creates a filesystem blob store, creating root if needed
+func NewFS(root string) (*FS, error) {
+	if err := os.MkdirAll(filepath.Join(root, metaDir), 0o755); err != nil {
+		return nil, err
+	}
+	return &FS{root: root}, nil
+}
+
+// fsMeta is what is kept next to a blob since files have no content type nor metadata
+type fsMeta struct {
+	ContentType string            `json:"content_type"`
+	ETag        string            `json:"etag"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+}
+
+func (s *FS) paths(bucket, key string) (blob, meta string, err error) {
+	if _, err := cleanKey(bucket); err != nil || strings.Contains(bucket, "/") || bucket == metaDir {
+		return "", "", ErrInvalidKey
+	}
+	if _, err := cleanKey(key); err != nil {
+		return "", "", err
+	}
+	blob = filepath.Join(s.root, bucket, filepath.FromSlash(key))
+	meta = filepath.Join(s.root, metaDir, bucket, filepath.FromSlash(key)+".json")
+	return blob, meta, nil
+}
+
+func (s *FS) MakeBucket(ctx context.Context, bucket string) error {
+	if _, err := cleanKey(bucket); err != nil || strings.Contains(bucket, "/") || bucket == metaDir {
+		return ErrInvalidKey
+	}
+	return os.MkdirAll(filepath.Join(s.root, bucket), 0o755)
+}
+
+// Put writes the blob to a temporary file first so readers never see a partial blob
+func (s *FS) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, opts PutOptions) (Info, error) {
+	blob, meta, err := s.paths(bucket, key)
+	if err != nil {
+		return Info{}, err
+	}
+	if _, err := os.Stat(filepath.Join(s.root, bucket)); errors.Is(err, fs.ErrNotExist) {
+		return Info{}, ErrNotFound
+	}
+	if err := os.MkdirAll(filepath.Dir(blob), 0o755); err != nil {
+		return Info{}, err
+	}
+	if err := os.MkdirAll(filepath.Dir(meta), 0o755); err != nil {
+		return Info{}, err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(blob), ".upload-*")
+	if err != nil {
+		return Info{}, err
+	}
+	defer os.Remove(f.Name())
+
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	if size >= 0 && n != size {
+		return Info{}, io.ErrUnexpectedEOF
+	}
+
+	m := fsMeta{ContentType: opts.ContentType, ETag: hex.EncodeToString(h.Sum(nil)), Metadata: opts.Metadata}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return Info{}, err
+	}
+	if err := os.WriteFile(meta, b, 0o644); err != nil {
+		return Info{}, err
+	}
+	if err := os.Rename(f.Name(), blob); err != nil {
+		return Info{}, err
+	}
+	return s.Stat(ctx, bucket, key)
+}
+
+func (s *FS) Get(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	blob, _, err := s.paths(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(blob)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (s *FS) Stat(ctx context.Context, bucket, key string) (Info, error) {
+	blob, meta, err := s.paths(bucket, key)
+	if err != nil {
+		return Info{}, err
+	}
+	fi, err := os.Stat(blob)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, err
+	}
+	if fi.IsDir() {
+		return Info{}, ErrNotFound
+	}
+
+	m := fsMeta{}
+	if b, err := os.ReadFile(meta); err == nil {
+		if err := json.Unmarshal(b, &m); err != nil {
+			return Info{}, err
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return Info{}, err
+	}
+	return Info{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  m.ContentType,
+		ETag:         m.ETag,
+		LastModified: fi.ModTime().UTC(),
+		Metadata:     m.Metadata,
+	}, nil
+}
+
+func (s *FS) Delete(ctx context.Context, bucket, key string) error {
+	blob, meta, err := s.paths(bucket, key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(blob); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(meta); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FS) List(ctx context.Context, bucket, prefix string) ([]Info, error) {
+	if _, err := cleanKey(bucket); err != nil || strings.Contains(bucket, "/") || bucket == metaDir {
+		return nil, ErrInvalidKey
+	}
+	dir := filepath.Join(s.root, bucket)
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	infos := []Info{}
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		// skip the temporary files of the uploads in progress
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := s.Stat(ctx, bucket, key)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, info)
+		return nil
+	})
+	return infos, err
+}
+
+func (s *FS) PresignGet(ctx context.Context, bucket, key string, expiry time.Duration, params url.Values) (*url.URL, error) {
+	return nil, ErrNotSupported
+}
+
+// uploadsDir keeps the parts of the multipart uploads, inside metaDir so it cannot clash with a bucket
+const uploadsDir = "uploads"
+
+// fsUpload is what is kept about a multipart upload until it is completed
+type fsUpload struct {
+	Bucket string     `json:"bucket"`
+	Key    string     `json:"key"`
+	Opts   PutOptions `json:"opts"`
+}
+
+// uploadDir is the directory of the parts of the upload, the id must be one made by NewMultipart
+func (s *FS) uploadDir(uploadID string) (string, error) {
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.root, metaDir, uploadsDir, uploadID), nil
+}
+
+// upload reads the upload and checks that it is the one of the blob
+func (s *FS) upload(bucket, key, uploadID string) (string, fsUpload, error) {
+	u := fsUpload{}
+	dir, err := s.uploadDir(uploadID)
+	if err != nil {
+		return "", u, err
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", u, ErrNotFound
+	} else if err != nil {
+		return "", u, err
+	}
+	if err := json.Unmarshal(b, &u); err != nil {
+		return "", u, err
+	}
+	if u.Bucket != bucket || u.Key != key {
+		return "", u, ErrNotFound
+	}
+	return dir, u, nil
+}
+
+func (s *FS) NewMultipart(ctx context.Context, bucket, key string, opts PutOptions) (string, error) {
+	if _, _, err := s.paths(bucket, key); err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(s.root, bucket)); errors.Is(err, fs.ErrNotExist) {
+		return "", ErrNotFound
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+	dir, _ := s.uploadDir(uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(fsUpload{Bucket: bucket, Key: key, Opts: opts})
+	if err != nil {
+		return "", err
+	}
+	return uploadID, os.WriteFile(filepath.Join(dir, "upload.json"), b, 0o644)
+}
+
+func (s *FS) PutPart(ctx context.Context, bucket, key, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	dir, _, err := s.upload(bucket, key, uploadID)
+	if err != nil {
+		return Part{}, err
+	}
+
+	f, err := os.CreateTemp(dir, ".part-*")
+	if err != nil {
+		return Part{}, err
+	}
+	defer os.Remove(f.Name())
+
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(f, h), r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return Part{}, err
+	}
+	if size >= 0 && n != size {
+		return Part{}, io.ErrUnexpectedEOF
+	}
+	// a part uploaded again replaces the previous one, as in S3
+	if err := os.Rename(f.Name(), filepath.Join(dir, strconv.Itoa(number))); err != nil {
+		return Part{}, err
+	}
+	return Part{Number: number, ETag: hex.EncodeToString(h.Sum(nil)), Size: n}, nil
+}
+
+func (s *FS) CompleteMultipart(ctx context.Context, bucket, key, uploadID string, parts []Part) (Info, error) {
+	dir, u, err := s.upload(bucket, key, uploadID)
+	if err != nil {
+		return Info{}, err
+	}
+
+	readers := []io.Reader{}
+	size := int64(0)
+	for _, p := range parts {
+		f, err := os.Open(filepath.Join(dir, strconv.Itoa(p.Number)))
+		if errors.Is(err, fs.ErrNotExist) {
+			return Info{}, fmt.Errorf("part %d: %w", p.Number, ErrNotFound)
+		} else if err != nil {
+			return Info{}, err
+		}
+		defer f.Close()
+		readers = append(readers, f)
+		size += p.Size
+	}
+
+	info, err := s.Put(ctx, bucket, key, io.MultiReader(readers...), size, u.Opts)
+	if err != nil {
+		return Info{}, err
+	}
+	return info, os.RemoveAll(dir)
+}
+
+func (s *FS) AbortMultipart(ctx context.Context, bucket, key, uploadID string) error {
+	dir, _, err := s.upload(bucket, key, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
@@ -0,0 +1,200 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFS(t *testing.T) *FS {
+	s, err := NewFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MakeBucket(context.Background(), "media"); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestFSPutGet(t *testing.T) {
+	ctx := context.Background()
+	s := newTestFS(t)
+
+	body := "hello, blob"
+	info, err := s.Put(ctx, "media", "a/b.txt", strings.NewReader(body), int64(len(body)), PutOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"filename": "b.txt"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(body)) || info.ContentType != "text/plain" || info.ETag == "" {
+		t.Errorf("Put() = %+v", info)
+	}
+
+	got, err := s.Stat(ctx, "media", "a/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Metadata["filename"] != "b.txt" || got.ETag != info.ETag {
+		t.Errorf("Stat() = %+v, want the metadata of Put()", got)
+	}
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, body},
+		{7, -1, "blob"},
+		{0, 5, "hello"},
+		{7, 100, "blob"},
+	}
+	for _, tt := range tests {
+		r, err := s.Get(ctx, "media", "a/b.txt", tt.offset, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("Get(%d, %d) = %q, want %q", tt.offset, tt.length, b, tt.want)
+		}
+	}
+}
+
+func TestFSPutShort(t *testing.T) {
+	s := newTestFS(t)
+	_, err := s.Put(context.Background(), "media", "short", strings.NewReader("abc"), 10, PutOptions{})
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Put() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+	if _, err := s.Stat(context.Background(), "media", "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() error = %v, want the partial blob to be dropped", err)
+	}
+}
+
+func TestFSListDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestFS(t)
+	for _, key := range []string{"1/a", "1/b", "2/c"} {
+		if _, err := s.Put(ctx, "media", key, strings.NewReader(key), -1, PutOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := s.List(ctx, "media", "1/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 2 || infos[0].Key != "1/a" || infos[1].Key != "1/b" {
+		t.Errorf("List(1/) = %+v", infos)
+	}
+
+	if err := s.Delete(ctx, "media", "1/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "media", "1/a"); err != nil {
+		t.Errorf("Delete() of a missing blob = %v, want nil", err)
+	}
+	if _, err := s.Get(ctx, "media", "1/a", 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := s.List(ctx, "missing", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("List() of a missing bucket error = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestFSInvalidKey(t *testing.T) {
+	s := newTestFS(t)
+	for _, key := range []string{"", "/abs", "../up", "a/../../up", "a//b", `a\b`} {
+		if _, err := s.Put(context.Background(), "media", key, strings.NewReader("x"), 1, PutOptions{}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) error = %v, want %v", key, err, ErrInvalidKey)
+		}
+	}
+	if _, err := s.Stat(context.Background(), "..", "x"); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("Stat() in bucket %q error = %v, want %v", "..", err, ErrInvalidKey)
+	}
+	if _, err := s.PresignGet(context.Background(), "media", "x", 0, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("PresignGet() error = %v, want %v", err, ErrNotSupported)
+	}
+}
+
+func TestReadSeekerServeContent(t *testing.T) {
+	ctx := context.Background()
+	s := newTestFS(t)
+	body := bytes.Repeat([]byte("0123456789"), 100)
+	if _, err := s.Put(ctx, "media", "digits", bytes.NewReader(body), int64(len(body)), PutOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/digits", nil)
+	req.Header.Set("Range", "bytes=995-")
+	w := httptest.NewRecorder()
+	rs := NewReadSeeker(ctx, s, "media", "digits", int64(len(body)))
+	defer rs.Close()
+	http.ServeContent(w, req, "digits", time.Time{}, rs)
+
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("ServeContent() status = %d, want %d", w.Code, http.StatusPartialContent)
+	}
+	if got := w.Body.String(); got != "56789" {
+		t.Errorf("ServeContent() body = %q, want %q", got, "56789")
+	}
+}
+
+func TestFSMultipart(t *testing.T) {
+	ctx := context.Background()
+	s := newTestFS(t)
+	var mp Multipart = s
+
+	id, err := mp.NewMultipart(ctx, "media", "1/video.mp4", PutOptions{ContentType: "video/mp4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := []Part{}
+	for i, chunk := range []string{"first ", "second ", "last"} {
+		p, err := mp.PutPart(ctx, "media", "1/video.mp4", id, i+1, strings.NewReader(chunk), int64(len(chunk)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, p)
+	}
+	if _, err := s.Stat(ctx, "media", "1/video.mp4"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() before CompleteMultipart() error = %v, want %v", err, ErrNotFound)
+	}
+
+	info, err := mp.CompleteMultipart(ctx, "media", "1/video.mp4", id, parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 17 || info.ContentType != "video/mp4" {
+		t.Errorf("CompleteMultipart() = %+v", info)
+	}
+	r, err := s.Get(ctx, "media", "1/video.mp4", 0, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "first second last" {
+		t.Errorf("Get() = %q", b)
+	}
+
+	// the upload is gone once completed
+	if err := mp.AbortMultipart(ctx, "media", "1/video.mp4", id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("AbortMultipart() after CompleteMultipart() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := mp.PutPart(ctx, "media", "1/video.mp4", "../../x", 1, strings.NewReader("x"), 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("PutPart() with an invalid upload id error = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,154 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gocs/pensive/pkg/objectstore"
+	"github.com/minio/minio-go/v7"
+)
+
+// Minio stores the blobs in a MinIO or any S3 compatible server
+type Minio struct {
+	objs   *objectstore.ObjectStore
+	region string
+}
+
+// NewMinio wraps the object store, buckets are made in region
+func NewMinio(objs *objectstore.ObjectStore, region string) *Minio {
+	return &Minio{objs: objs, region: region}
+}
+
+func (s *Minio) MakeBucket(ctx context.Context, bucket string) error {
+	return s.objs.MakeBucket(ctx, bucket, objectstore.MakeBucketOptions{Region: s.region})
+}
+
+func (s *Minio) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, opts PutOptions) (Info, error) {
+	if _, err := cleanKey(key); err != nil {
+		return Info{}, err
+	}
+	info, err := s.objs.SaveObject(ctx, bucket, key, r, size, objectstore.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: opts.Metadata,
+	})
+	if err != nil {
+		return Info{}, minioErr(err)
+	}
+	return Info{
+		Key:          key,
+		Size:         info.Size,
+		ContentType:  opts.ContentType,
+		ETag:         info.ETag,
+		LastModified: time.Now().UTC(),
+		Metadata:     opts.Metadata,
+	}, nil
+}
+
+func (s *Minio) Get(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	if length == 0 {
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	obj, err := s.objs.GetObjectRange(ctx, bucket, key, offset, length)
+	if err != nil {
+		return nil, minioErr(err)
+	}
+	// the object is lazy, stat it so a missing blob fails here rather than on the first read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, minioErr(err)
+	}
+	return obj, nil
+}
+
+func (s *Minio) Stat(ctx context.Context, bucket, key string) (Info, error) {
+	info, err := s.objs.StatObject(ctx, bucket, key)
+	if err != nil {
+		return Info{}, minioErr(err)
+	}
+	return minioInfo(info), nil
+}
+
+func (s *Minio) Delete(ctx context.Context, bucket, key string) error {
+	return minioErr(s.objs.RemoveObject(ctx, bucket, key))
+}
+
+func (s *Minio) List(ctx context.Context, bucket, prefix string) ([]Info, error) {
+	infos := []Info{}
+	for obj := range s.objs.ListObjects(ctx, bucket, objectstore.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, minioErr(obj.Err)
+		}
+		infos = append(infos, minioInfo(obj))
+	}
+	return infos, nil
+}
+
+func (s *Minio) PresignGet(ctx context.Context, bucket, key string, expiry time.Duration, params url.Values) (*url.URL, error) {
+	u, err := s.objs.GetPresignedURLObject(ctx, bucket, key, objectstore.PresignedGetObjectOptions{
+		ReqParams:              params,
+		PresignedURLExpiration: expiry,
+	})
+	return u, minioErr(err)
+}
+
+func (s *Minio) NewMultipart(ctx context.Context, bucket, key string, opts PutOptions) (string, error) {
+	if _, err := cleanKey(key); err != nil {
+		return "", err
+	}
+	id, err := s.objs.NewMultipartUpload(ctx, bucket, key, objectstore.PutObjectOptions{
+		ContentType:  opts.ContentType,
+		UserMetadata: opts.Metadata,
+	})
+	return id, minioErr(err)
+}
+
+func (s *Minio) PutPart(ctx context.Context, bucket, key, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	part, err := s.objs.PutObjectPart(ctx, bucket, key, uploadID, number, r, size)
+	if err != nil {
+		return Part{}, minioErr(err)
+	}
+	return Part{Number: part.PartNumber, ETag: part.ETag, Size: part.Size}, nil
+}
+
+func (s *Minio) CompleteMultipart(ctx context.Context, bucket, key, uploadID string, parts []Part) (Info, error) {
+	complete := make([]minio.CompletePart, len(parts))
+	for i, p := range parts {
+		complete[i] = minio.CompletePart{PartNumber: p.Number, ETag: p.ETag}
+	}
+	if _, err := s.objs.CompleteMultipartUpload(ctx, bucket, key, uploadID, complete); err != nil {
+		return Info{}, minioErr(err)
+	}
+	return s.Stat(ctx, bucket, key)
+}
+
+func (s *Minio) AbortMultipart(ctx context.Context, bucket, key, uploadID string) error {
+	return minioErr(s.objs.AbortMultipartUpload(ctx, bucket, key, uploadID))
+}
+
+func minioInfo(info minio.ObjectInfo) Info {
+	// minio gives the user metadata back in canonical header form, e.g. "Filename"
+	meta := make(map[string]string, len(info.UserMetadata))
+	for k, v := range info.UserMetadata {
+		meta[strings.ToLower(k)] = v
+	}
+	return Info{
+		Key:          info.Key,
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		Metadata:     meta,
+	}
+}
+
+// minioErr turns the missing bucket and object errors into ErrNotFound
+func minioErr(err error) error {
+	if err != nil && objectstore.IsNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
@@ -0,0 +1,29 @@
+package blobstore
+
+import (
+	"context"
+	"io"
+)
+
+// MinPartSize is the smallest size of every part but the last one, as S3 requires
+const MinPartSize = 5 << 20
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number int    `json:"number"` // starts at 1
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// Multipart is implemented by the stores that can assemble a blob from parts uploaded separately
+// the blob only appears once the upload is completed
+type Multipart interface {
+	// NewMultipart starts the upload of the blob and gives its id
+	NewMultipart(ctx context.Context, bucket, key string, opts PutOptions) (string, error)
+	// PutPart uploads a part, every part but the last one must be at least MinPartSize
+	PutPart(ctx context.Context, bucket, key, uploadID string, number int, r io.Reader, size int64) (Part, error)
+	// CompleteMultipart assembles the parts in order into the blob
+	CompleteMultipart(ctx context.Context, bucket, key, uploadID string, parts []Part) (Info, error)
+	// AbortMultipart drops the parts uploaded so far
+	AbortMultipart(ctx context.Context, bucket, key, uploadID string) error
+}
@@ -0,0 +1,144 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gocs/pensive/pkg/store"
+)
+
+// SeaweedFS stores the blobs through a SeaweedFS filer, the buckets are directories under the root of the filer
+// the filer has no signed urls so PresignGet is not supported
+type SeaweedFS struct {
+	c *store.Client
+}
+
+// NewSeaweedFS stores the blobs with the client, which must have a filer url
+func NewSeaweedFS(c *store.Client) *SeaweedFS {
+	return &SeaweedFS{c: c}
+}
+
+// seaweedErr turns the missing files into ErrNotFound
+func seaweedErr(err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// MakeBucket does nothing as the filer makes the directories on the first upload
+func (s *SeaweedFS) MakeBucket(ctx context.Context, bucket string) error {
+	if _, err := cleanKey(bucket); err != nil || strings.Contains(bucket, "/") {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+func (s *SeaweedFS) Put(ctx context.Context, bucket, key string, r io.Reader, size int64, opts PutOptions) (Info, error) {
+	if _, err := cleanKey(key); err != nil {
+		return Info{}, err
+	}
+	up, err := s.c.FilerPut(ctx, path.Join(bucket, key), store.File{ContentType: opts.ContentType, Metadata: opts.Metadata}, r)
+	if err != nil {
+		return Info{}, seaweedErr(err)
+	}
+	if size >= 0 && up.Size != size {
+		return Info{}, io.ErrUnexpectedEOF
+	}
+	return Info{
+		Key:          key,
+		Size:         up.Size,
+		ContentType:  opts.ContentType,
+		ETag:         up.ETag,
+		LastModified: time.Now().UTC(),
+		Metadata:     opts.Metadata,
+	}, nil
+}
+
+func (s *SeaweedFS) Get(ctx context.Context, bucket, key string, offset, length int64) (io.ReadCloser, error) {
+	if _, err := cleanKey(key); err != nil {
+		return nil, err
+	}
+	r, err := s.c.FilerGet(ctx, path.Join(bucket, key), offset, length)
+	return r, seaweedErr(err)
+}
+
+func (s *SeaweedFS) Stat(ctx context.Context, bucket, key string) (Info, error) {
+	if _, err := cleanKey(key); err != nil {
+		return Info{}, err
+	}
+	fi, err := s.c.FilerStat(ctx, path.Join(bucket, key))
+	if err != nil {
+		return Info{}, seaweedErr(err)
+	}
+	return Info{
+		Key:          key,
+		Size:         fi.Size,
+		ContentType:  fi.ContentType,
+		ETag:         fi.ETag,
+		LastModified: fi.LastModified,
+		Metadata:     fi.Metadata,
+	}, nil
+}
+
+func (s *SeaweedFS) Delete(ctx context.Context, bucket, key string) error {
+	if _, err := cleanKey(key); err != nil {
+		return err
+	}
+	if err := s.c.FilerDelete(ctx, path.Join(bucket, key), false); err != nil && !errors.Is(err, store.ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// seaweedPageSize is how many entries are asked per listing page
+const seaweedPageSize = 1000
+
+// List walks the directories of the bucket, the filer only lists one directory at a time
+func (s *SeaweedFS) List(ctx context.Context, bucket, prefix string) ([]Info, error) {
+	infos := []Info{}
+	dirs := []string{""}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		last := ""
+		for {
+			listing, err := s.c.FilerList(ctx, path.Join(bucket, dir), last, seaweedPageSize)
+			if errors.Is(err, store.ErrNotFound) && dir != "" {
+				break // removed while walking
+			} else if err != nil {
+				return nil, seaweedErr(err)
+			}
+
+			for _, e := range listing.Entries {
+				key := path.Join(dir, path.Base(e.FullPath))
+				if e.IsDir() {
+					// only walk the directories that can hold keys with the prefix
+					if strings.HasPrefix(key+"/", prefix) || strings.HasPrefix(prefix, key+"/") {
+						dirs = append(dirs, key)
+					}
+					continue
+				}
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+				infos = append(infos, Info{Key: key, Size: e.FileSize, ContentType: e.Mime, LastModified: e.Mtime.UTC()})
+			}
+			if !listing.ShouldDisplayLoadMore || listing.LastFileName == "" {
+				break
+			}
+			last = listing.LastFileName
+		}
+	}
+	return infos, nil
+}
+
+func (s *SeaweedFS) PresignGet(ctx context.Context, bucket, key string, expiry time.Duration, params url.Values) (*url.URL, error) {
+	return nil, ErrNotSupported
+}
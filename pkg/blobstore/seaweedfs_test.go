@@ -0,0 +1,115 @@
+package blobstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocs/pensive/pkg/store"
+)
+
+type fakeBlob struct {
+	data   []byte
+	mime   string
+	header http.Header
+}
+
+// fakeFiler keeps the uploaded files in memory, it does not list directories
+func fakeFiler(t *testing.T) *httptest.Server {
+	blobs := map[string]fakeBlob{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			f, fh, err := r.FormFile("file")
+			if err != nil {
+				t.Errorf("filer: %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(f)
+			meta := http.Header{}
+			for k, v := range r.Header {
+				if strings.HasPrefix(k, store.MetadataPrefix) {
+					meta[k] = v
+				}
+			}
+			blobs[r.URL.Path] = fakeBlob{data: data, mime: fh.Header.Get("Content-Type"), header: meta}
+			fmt.Fprintf(w, `{"name":%q,"size":%d}`, fh.Filename, len(data))
+		case http.MethodGet, http.MethodHead:
+			b, ok := blobs[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			for k, v := range b.header {
+				w.Header()[k] = v
+			}
+			w.Header().Set("Content-Type", b.mime)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b.data))
+		case http.MethodDelete:
+			if _, ok := blobs[r.URL.Path]; !ok {
+				http.NotFound(w, r)
+				return
+			}
+			delete(blobs, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+}
+
+func TestSeaweedFS(t *testing.T) {
+	ctx := context.Background()
+	srv := fakeFiler(t)
+	defer srv.Close()
+	c, err := store.New(store.Options{FilerURL: srv.URL, HTTPClient: srv.Client()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSeaweedFS(c)
+
+	body := "hello, blob"
+	if _, err := s.Put(ctx, "media", "1/b.txt", strings.NewReader(body), int64(len(body)), PutOptions{
+		ContentType: "text/plain",
+		Metadata:    map[string]string{"filename": "b.txt"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := s.Stat(ctx, "media", "1/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != int64(len(body)) || info.ContentType != "text/plain" || info.Metadata["filename"] != "b.txt" {
+		t.Errorf("Stat() = %+v", info)
+	}
+
+	r, err := s.Get(ctx, "media", "1/b.txt", 7, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "blob" {
+		t.Errorf("Get(7, -1) = %q, want %q", b, "blob")
+	}
+
+	if err := s.Delete(ctx, "media", "1/b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "media", "1/b.txt"); err != nil {
+		t.Errorf("Delete() of a missing blob = %v, want nil", err)
+	}
+	if _, err := s.Stat(ctx, "media", "1/b.txt"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Stat() after Delete() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := s.PresignGet(ctx, "media", "1/b.txt", time.Minute, nil); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("PresignGet() error = %v, want %v", err, ErrNotSupported)
+	}
+}
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"path/filepath"
 	"strings"
 )
 
@@ -57,10 +58,8 @@ func ContentTypes() map[string]string {
 
 // GetMediaType gets extension from filename
 func GetMediaType(filename string) string {
-	for ext, format := range MediaTypes() {
-		if strings.HasSuffix(filename, ext) {
-			return format
-		}
+	if format, ok := MediaTypes()[strings.ToLower(filepath.Ext(filename))]; ok {
+		return format
 	}
 
 	return "none"
@@ -68,10 +67,8 @@ func GetMediaType(filename string) string {
 
 // DetectContentType gets ContentType from filename
 func DetectContentType(filename string) string {
-	for ext, format := range ContentTypes() {
-		if strings.HasSuffix(filename, ext) {
-			return format
-		}
+	if format, ok := ContentTypes()[strings.ToLower(filepath.Ext(filename))]; ok {
+		return format
 	}
 
 	return "none"
@@ -36,6 +36,8 @@ func TestDetectContentType(t *testing.T) {
 	}{
 		{name: "raw", args: args{"bin"}, want: "none"},
 		{name: "jpg file", args: args{"bin.jpg"}, want: "image/jpeg"},
+		{name: "jpeg file", args: args{"bin.jpeg"}, want: "image/jpeg"},
+		{name: "uppercase", args: args{"BIN.PNG"}, want: "image/png"},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
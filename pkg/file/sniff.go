@@ -0,0 +1,166 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	// ErrUnsupportedType is returned when the content of an upload is not an allowed media
+	ErrUnsupportedType = errors.New("unsupported media type")
+	// ErrTooLarge is returned when an upload is larger than the limit of its category
+	ErrTooLarge = errors.New("media is too large")
+)
+
+// sniffLen is how many leading bytes are read to detect the type of an upload
+const sniffLen = 512
+
+// MediaInfo is what the content of an upload turned out to be
+type MediaInfo struct {
+	MIME      string // e.g. "image/png"
+	Category  string // "image", "audio", "video", or "text" like MediaTypes
+	Extension string // canonical extension with the dot, e.g. ".png"
+}
+
+// signature matches the leading bytes of a format
+type signature struct {
+	info  MediaInfo
+	match func(b []byte) bool
+}
+
+func prefix(offset int, magic string) func(b []byte) bool {
+	return func(b []byte) bool {
+		return len(b) >= offset+len(magic) && string(b[offset:offset+len(magic)]) == magic
+	}
+}
+
+func riff(form string) func(b []byte) bool {
+	return func(b []byte) bool { return prefix(0, "RIFF")(b) && prefix(8, form)(b) }
+}
+
+// signatures are checked in order so the more specific ones come first
+var signatures = []signature{
+	{MediaInfo{"image/jpeg", "image", ".jpg"}, prefix(0, "\xFF\xD8\xFF")},
+	{MediaInfo{"image/png", "image", ".png"}, prefix(0, "\x89PNG\r\n\x1A\n")},
+	{MediaInfo{"image/gif", "image", ".gif"}, func(b []byte) bool { return prefix(0, "GIF87a")(b) || prefix(0, "GIF89a")(b) }},
+	{MediaInfo{"image/webp", "image", ".webp"}, riff("WEBP")},
+
+	{MediaInfo{"audio/wav", "audio", ".wav"}, riff("WAVE")},
+	{MediaInfo{"audio/flac", "audio", ".flac"}, prefix(0, "fLaC")},
+	// adts frames have the layer bits unset, mpeg audio frames do not
+	{MediaInfo{"audio/aac", "audio", ".aac"}, func(b []byte) bool { return len(b) >= 2 && b[0] == 0xFF && b[1]&0xF6 == 0xF0 }},
+	{MediaInfo{"audio/mpeg", "audio", ".mp3"}, func(b []byte) bool {
+		return prefix(0, "ID3")(b) || (len(b) >= 2 && b[0] == 0xFF && b[1]&0xE0 == 0xE0 && b[1]&0x06 != 0)
+	}},
+
+	{MediaInfo{"video/quicktime", "video", ".mov"}, func(b []byte) bool { return prefix(4, "ftyp")(b) && prefix(8, "qt  ")(b) }},
+	{MediaInfo{"video/mp4", "video", ".mp4"}, prefix(4, "ftyp")},
+	// webm is matroska with its own doctype
+	{MediaInfo{"video/webm", "video", ".webm"}, func(b []byte) bool {
+		return prefix(0, "\x1A\x45\xDF\xA3")(b) && bytes.Contains(b[:min(len(b), 64)], []byte("webm"))
+	}},
+	{MediaInfo{"video/x-matroska", "video", ".mkv"}, prefix(0, "\x1A\x45\xDF\xA3")},
+	{MediaInfo{"video/x-ms-wmv", "video", ".wmv"}, prefix(0, "\x30\x26\xB2\x75\x8E\x66\xCF\x11")},
+	{MediaInfo{"video/x-msvideo", "video", ".avi"}, riff("AVI ")},
+
+	{MediaInfo{"text/plain", "text", ".txt"}, isText},
+}
+
+// isText tells if the bytes look like utf-8 text without control characters
+// the last rune may be cut by the sniff length so it is not checked
+// markup is not text since a browser may render it as a page, see isMarkup
+func isText(b []byte) bool {
+	if len(b) == 0 || isMarkup(b) {
+		return false
+	}
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		if r == utf8.RuneError && size <= 1 {
+			return len(b) < utf8.UTFMax && !utf8.FullRune(b)
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+		b = b[size:]
+	}
+	return true
+}
+
+// isMarkup tells if the text is html, xml, or svg
+func isMarkup(b []byte) bool {
+	if ct := http.DetectContentType(b); strings.HasPrefix(ct, "text/html") || strings.HasPrefix(ct, "text/xml") {
+		return true
+	}
+	head := bytes.ToLower(bytes.TrimLeft(bytes.TrimPrefix(b, []byte("\xEF\xBB\xBF")), " \t\r\n"))
+	return bytes.HasPrefix(head, []byte("<svg")) || bytes.HasPrefix(head, []byte("<?xml"))
+}
+
+// Sniff detects the media from the leading bytes of its content, ignoring its filename
+func Sniff(b []byte) (MediaInfo, bool) {
+	for _, s := range signatures {
+		if s.match(b) {
+			return s.info, true
+		}
+	}
+	return MediaInfo{}, false
+}
+
+// Policy is the allow-list and the size limits of uploads
+type Policy struct {
+	// Allowed lists the accepted MIME types
+	Allowed []string
+	// MaxSize is the limit in bytes per category; categories without one have no limit
+	MaxSize map[string]int64
+}
+
+// DefaultPolicy allows every media Sniff detects, with limits suited to the category
+func DefaultPolicy() Policy {
+	allowed := []string{}
+	for _, s := range signatures {
+		allowed = append(allowed, s.info.MIME)
+	}
+	return Policy{
+		Allowed: allowed,
+		MaxSize: map[string]int64{
+			"image": 10 << 20,
+			"audio": 50 << 20,
+			"video": 500 << 20,
+			"text":  1 << 20,
+		},
+	}
+}
+
+// Inspect sniffs the upload and checks it against the policy
+// size is the size of the whole upload
+// the returned reader yields the whole upload, including what was read to sniff it
+func (p Policy) Inspect(r io.Reader, size int64) (MediaInfo, io.Reader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return MediaInfo{}, nil, err
+	}
+	head = head[:n]
+
+	info, ok := Sniff(head)
+	if !ok || !p.allows(info.MIME) {
+		return MediaInfo{}, nil, ErrUnsupportedType
+	}
+	if limit, ok := p.MaxSize[info.Category]; ok && size > limit {
+		return MediaInfo{}, nil, fmt.Errorf("%w: %s is limited to %d bytes", ErrTooLarge, info.Category, limit)
+	}
+	return info, io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+func (p Policy) allows(mime string) bool {
+	for _, allowed := range p.Allowed {
+		if allowed == mime {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package file
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	tests := []struct {
+		name   string
+		head   string
+		want   string
+		wantOk bool
+	}{
+		{name: "jpeg", head: "\xFF\xD8\xFF\xE0\x00\x10JFIF", want: "image/jpeg", wantOk: true},
+		{name: "png", head: "\x89PNG\r\n\x1A\n\x00\x00\x00\rIHDR", want: "image/png", wantOk: true},
+		{name: "gif", head: "GIF89a\x01\x00", want: "image/gif", wantOk: true},
+		{name: "webp", head: "RIFF\x00\x00\x00\x00WEBPVP8 ", want: "image/webp", wantOk: true},
+		{name: "wav", head: "RIFF\x00\x00\x00\x00WAVEfmt ", want: "audio/wav", wantOk: true},
+		{name: "mp3 id3", head: "ID3\x03\x00", want: "audio/mpeg", wantOk: true},
+		{name: "mp3 frame", head: "\xFF\xFB\x90\x00", want: "audio/mpeg", wantOk: true},
+		{name: "aac", head: "\xFF\xF1\x50\x80", want: "audio/aac", wantOk: true},
+		{name: "mp4", head: "\x00\x00\x00\x18ftypisom", want: "video/mp4", wantOk: true},
+		{name: "mov", head: "\x00\x00\x00\x14ftypqt  ", want: "video/quicktime", wantOk: true},
+		{name: "webm", head: "\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x84webm", want: "video/webm", wantOk: true},
+		{name: "mkv", head: "\x1A\x45\xDF\xA3\xA3\x42\x86\x81\x01\x42\x82\x88matroska", want: "video/x-matroska", wantOk: true},
+		{name: "text", head: "hello, world\n", want: "text/plain", wantOk: true},
+		{name: "html", head: "<!DOCTYPE html><script>alert(1)</script>", wantOk: false},
+		{name: "html fragment", head: "\n  <script>alert(1)</script>", wantOk: false},
+		{name: "svg", head: "<svg xmlns=\"http://www.w3.org/2000/svg\" onload=\"alert(1)\"/>", wantOk: false},
+		{name: "xml", head: "\xEF\xBB\xBF<?xml version=\"1.0\"?><svg/>", wantOk: false},
+		{name: "binary", head: "\x00\x01\x02\x03", wantOk: false},
+		{name: "empty", head: "", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Sniff([]byte(tt.head))
+			if ok != tt.wantOk {
+				t.Fatalf("Sniff() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if got.MIME != tt.want {
+				t.Errorf("Sniff() = %v, want %v", got.MIME, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyInspect(t *testing.T) {
+	png := "\x89PNG\r\n\x1A\n" + strings.Repeat("\x00", 600)
+	p := Policy{
+		Allowed: []string{"image/png"},
+		MaxSize: map[string]int64{"image": 1024},
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		size    int64
+		wantErr error
+	}{
+		{name: "allowed", content: png, size: int64(len(png))},
+		{name: "not allowed", content: "GIF89a\x01\x00", size: 8, wantErr: ErrUnsupportedType},
+		{name: "unknown", content: "\x00\x01\x02\x03", size: 4, wantErr: ErrUnsupportedType},
+		{name: "too large", content: png, size: 2048, wantErr: ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, r, err := p.Inspect(strings.NewReader(tt.content), tt.size)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Inspect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if info.Extension != ".png" {
+				t.Errorf("Inspect() extension = %v, want .png", info.Extension)
+			}
+			// the sniffed bytes must not be lost
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, []byte(tt.content)) {
+				t.Errorf("Inspect() reader lost %d bytes", len(tt.content)-len(got))
+			}
+		})
+	}
+}
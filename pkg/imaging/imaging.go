@@ -0,0 +1,170 @@
+// Package imaging prepares uploaded pictures for the feed: it strips their metadata
+// and makes bounded-size derivatives using only the standard library
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the gif decoder for image.Decode
+	"image/jpeg"
+	"image/png"
+)
+
+var (
+	// ErrUnsupportedFormat is returned for images that are not JPEG, PNG, GIF, or WebP
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrTooManyPixels is returned for images larger than MaxPixels
+	ErrTooManyPixels = errors.New("image has too many pixels")
+)
+
+// MaxPixels bounds the size of the decoded images, a small file can declare a huge image
+// decoding takes 4 bytes per pixel, twice since the pixels are copied before resizing
+const MaxPixels = 40_000_000
+
+// Variant is a derivative to make, bounded to MaxSide pixels on its longest side
+type Variant struct {
+	Name    string
+	MaxSide int
+}
+
+// DefaultVariants are the derivatives shown in the feed
+var DefaultVariants = []Variant{
+	{Name: "thumb", MaxSide: 320},
+	{Name: "feed", MaxSide: 1080},
+}
+
+// Derivative is a resized copy of an image
+type Derivative struct {
+	Name      string
+	MIME      string
+	Extension string
+	Width     int
+	Height    int
+	Data      []byte
+}
+
+// Result is the processed image
+type Result struct {
+	Original    []byte // the image without its metadata
+	Width       int
+	Height      int
+	Derivatives []Derivative // only the variants smaller than the original are made
+}
+
+// jpegQuality is the quality of the derivatives encoded as JPEG
+const jpegQuality = 85
+
+// originalQuality is the quality of the originals encoded again after they are turned upright
+const originalQuality = 92
+
+// Process strips the metadata of the image and makes its variants
+// mime is the sniffed type of the image, e.g. "image/png"
+func Process(mime string, b []byte, variants []Variant) (Result, error) {
+	// the header is read first so a declared size too large to decode is never allocated
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return Result{}, err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxPixels {
+		return Result{}, ErrTooManyPixels
+	}
+
+	original, err := StripMetadata(mime, b)
+	if err != nil {
+		return Result{}, err
+	}
+
+	// animated gifs only keep their first frame in the derivatives
+	img, _, err := image.Decode(bytes.NewReader(original))
+	if err != nil {
+		return Result{}, err
+	}
+
+	bounds := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(src, src.Bounds(), img, bounds.Min, draw.Src)
+
+	// the orientation is stripped with the rest of the EXIF, so the pixels are turned upright instead
+	// only the rotated pictures are encoded again, the others keep their original pixels
+	if mime == "image/jpeg" {
+		if o := jpegOrientation(b); o != 1 {
+			src = orient(src, o)
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: originalQuality}); err != nil {
+				return Result{}, err
+			}
+			original = buf.Bytes()
+		}
+	}
+
+	res := Result{Original: original, Width: src.Bounds().Dx(), Height: src.Bounds().Dy()}
+	opaque := src.Opaque()
+
+	for _, v := range variants {
+		w, h := fit(res.Width, res.Height, v.MaxSide)
+		if w >= res.Width && h >= res.Height {
+			continue
+		}
+
+		d := Derivative{Name: v.Name, Width: w, Height: h}
+		var buf bytes.Buffer
+		if opaque {
+			d.MIME, d.Extension = "image/jpeg", ".jpg"
+			err = jpeg.Encode(&buf, downscale(src, w, h), &jpeg.Options{Quality: jpegQuality})
+		} else {
+			d.MIME, d.Extension = "image/png", ".png"
+			err = png.Encode(&buf, downscale(src, w, h))
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		d.Data = buf.Bytes()
+		res.Derivatives = append(res.Derivatives, d)
+	}
+	return res, nil
+}
+
+// fit scales the size down so its longest side is at most maxSide, keeping the aspect ratio
+func fit(w, h, maxSide int) (int, int) {
+	if w <= maxSide && h <= maxSide {
+		return w, h
+	}
+	if w >= h {
+		return maxSide, max(1, h*maxSide/w)
+	}
+	return max(1, w*maxSide/h), maxSide
+}
+
+// downscale resizes by averaging the source pixels covered by each destination pixel
+// the pixels are premultiplied so transparent ones do not darken the edges
+func downscale(src *image.RGBA, w, h int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := y*sh/h, max((y+1)*sh/h, y*sh/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := x*sw/w, max((x+1)*sw/w, x*sw/w+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,289 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func pngChunk(typ string, data []byte) []byte {
+	c := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(c, uint32(len(data)))
+	copy(c[4:], typ)
+	c = append(c, data...)
+	return binary.BigEndian.AppendUint32(c, crc32.ChecksumIEEE(c[4:]))
+}
+
+func testImage(w, h int, alpha uint8) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: alpha})
+		}
+	}
+	return img
+}
+
+func TestStripJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(16, 16, 255), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	// insert an exif segment with a fake gps tag after the start of image
+	exif := append([]byte("Exif\x00\x00"), []byte("GPSLatitude")...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	segment = append(segment, exif...)
+	withExif := append(append(append([]byte{}, encoded[:2]...), segment...), encoded[2:]...)
+
+	got, err := StripMetadata("image/jpeg", withExif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(got, []byte("GPSLatitude")) {
+		t.Error("StripMetadata() kept the exif segment")
+	}
+	if !bytes.Equal(got, encoded) {
+		t.Error("StripMetadata() changed more than the exif segment")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(got)); err != nil {
+		t.Errorf("StripMetadata() broke the image: %v", err)
+	}
+}
+
+func TestStripPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(16, 16, 255)); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	// insert textual and exif chunks after the header chunk, which is 25 bytes long
+	at := len(pngSignature) + 25
+	withMeta := append([]byte{}, encoded[:at]...)
+	withMeta = append(withMeta, pngChunk("tEXt", []byte("Author\x00someone"))...)
+	withMeta = append(withMeta, pngChunk("eXIf", []byte("GPSLatitude"))...)
+	withMeta = append(withMeta, encoded[at:]...)
+
+	got, err := StripMetadata("image/png", withMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, encoded) {
+		t.Error("StripMetadata() did not remove exactly the metadata chunks")
+	}
+}
+
+func TestStripGIF(t *testing.T) {
+	frame := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette{color.Black, color.White})
+	var buf bytes.Buffer
+	// the looping animation is written with a NETSCAPE2.0 extension
+	if err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+
+	// insert a comment and an xmp application extension before the trailer
+	comment := []byte("\x21\xFE\x0BGPSLatitude\x00")
+	xmp := []byte("\x21\xFF\x0BXMP DataXMP\x0BGPSLatitude\x00")
+	withMeta := append([]byte{}, encoded[:len(encoded)-1]...)
+	withMeta = append(append(append(withMeta, comment...), xmp...), 0x3B)
+
+	got, err := StripMetadata("image/gif", withMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, encoded) {
+		t.Error("StripMetadata() did not remove exactly the metadata extensions")
+	}
+	if _, err := gif.DecodeAll(bytes.NewReader(got)); err != nil {
+		t.Errorf("StripMetadata() broke the image: %v", err)
+	}
+}
+
+func riffChunk(typ string, data []byte) []byte {
+	c := append([]byte(typ), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	c = append(c, data...)
+	if len(data)%2 == 1 {
+		c = append(c, 0)
+	}
+	return c
+}
+
+func webp(chunks ...[]byte) []byte {
+	b := []byte("RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		b = append(b, c...)
+	}
+	binary.LittleEndian.PutUint32(b[4:8], uint32(len(b)-8))
+	return b
+}
+
+func TestStripWebP(t *testing.T) {
+	header := func(flags byte) []byte { return riffChunk("VP8X", []byte{flags, 0, 0, 0, 3, 0, 0, 3, 0, 0}) }
+	pixels := riffChunk("VP8L", []byte{0x2F, 1, 2, 3, 4})
+
+	withMeta := webp(header(0x0C), pixels, riffChunk("EXIF", []byte("GPSLatitude")), riffChunk("XMP ", []byte("<x:xmpmeta/>")))
+	got, err := StripMetadata("image/webp", withMeta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := webp(header(0), pixels); !bytes.Equal(got, want) {
+		t.Errorf("StripMetadata() = %q, want %q", got, want)
+	}
+}
+
+func TestStripUnsupported(t *testing.T) {
+	if _, err := StripMetadata("image/jpeg", []byte("not a jpeg")); err == nil {
+		t.Error("StripMetadata() accepted a broken jpeg")
+	}
+	if _, err := StripMetadata("image/webp", []byte("RIFF\x04\x00\x00\x00WEBPVP8")); err == nil {
+		t.Error("StripMetadata() accepted a broken webp")
+	}
+	if _, err := StripMetadata("image/bmp", []byte("BM")); err != ErrUnsupportedFormat {
+		t.Errorf("StripMetadata() error = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	tests := []struct {
+		name     string
+		img      image.Image
+		wantMIME string
+		wantSize map[string][2]int
+	}{
+		{
+			name:     "landscape",
+			img:      testImage(400, 200, 255),
+			wantMIME: "image/jpeg",
+			wantSize: map[string][2]int{"small": {100, 50}},
+		},
+		{
+			name:     "portrait with alpha",
+			img:      testImage(200, 400, 100),
+			wantMIME: "image/png",
+			wantSize: map[string][2]int{"small": {50, 100}},
+		},
+		{
+			name:     "smaller than every variant",
+			img:      testImage(50, 50, 255),
+			wantSize: map[string][2]int{},
+		},
+	}
+	variants := []Variant{{Name: "small", MaxSide: 100}, {Name: "big", MaxSide: 1000}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := png.Encode(&buf, tt.img); err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := Process("image/png", buf.Bytes(), variants)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Width != tt.img.Bounds().Dx() || res.Height != tt.img.Bounds().Dy() {
+				t.Errorf("Process() size = %dx%d", res.Width, res.Height)
+			}
+			if len(res.Derivatives) != len(tt.wantSize) {
+				t.Fatalf("Process() made %d derivatives, want %d", len(res.Derivatives), len(tt.wantSize))
+			}
+			for _, d := range res.Derivatives {
+				want := tt.wantSize[d.Name]
+				if d.Width != want[0] || d.Height != want[1] {
+					t.Errorf("Process() %s = %dx%d, want %dx%d", d.Name, d.Width, d.Height, want[0], want[1])
+				}
+				if d.MIME != tt.wantMIME {
+					t.Errorf("Process() %s mime = %v, want %v", d.Name, d.MIME, tt.wantMIME)
+				}
+				cfg, _, err := image.DecodeConfig(bytes.NewReader(d.Data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cfg.Width != d.Width || cfg.Height != d.Height {
+					t.Errorf("Process() %s encoded as %dx%d", d.Name, cfg.Width, cfg.Height)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessTooManyPixels(t *testing.T) {
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, testImage(1, 1, 255), nil); err != nil {
+		t.Fatal(err)
+	}
+	// declare a 65535x65535 logical screen in the header, the file stays a few bytes long
+	b := buf.Bytes()
+	binary.LittleEndian.PutUint16(b[6:], 0xFFFF)
+	binary.LittleEndian.PutUint16(b[8:], 0xFFFF)
+
+	if _, err := Process("image/gif", b, DefaultVariants); err != ErrTooManyPixels {
+		t.Errorf("Process() error = %v, want %v", err, ErrTooManyPixels)
+	}
+}
+
+// exifOrientation makes an APP1 segment holding only the orientation tag, in big endian
+func exifOrientation(o uint16) []byte {
+	tiff := []byte("MM\x00*\x00\x00\x00\x08")
+	tiff = binary.BigEndian.AppendUint16(tiff, 1)
+	tiff = append(tiff, 0x01, 0x12, 0, 3, 0, 0, 0, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, o)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+	exif := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	return append(segment, exif...)
+}
+
+func TestProcessOrientation(t *testing.T) {
+	// red on the left half and blue on the right half
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= 16 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	// orientation 6 is turned 90 degrees clockwise, so the left half ends up on top
+	rotated := append(append(append([]byte{}, encoded[:2]...), exifOrientation(6)...), encoded[2:]...)
+
+	res, err := Process("image/jpeg", rotated, []Variant{{Name: "small", MaxSide: 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Width != 16 || res.Height != 32 {
+		t.Fatalf("Process() size = %dx%d, want 16x32", res.Width, res.Height)
+	}
+	if bytes.Contains(res.Original, []byte("Exif")) {
+		t.Error("Process() kept the exif segment")
+	}
+	if d := res.Derivatives[0]; d.Width != 4 || d.Height != 8 {
+		t.Errorf("Process() small = %dx%d, want 4x8", d.Width, d.Height)
+	}
+
+	got, err := jpeg.Decode(bytes.NewReader(res.Original))
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, bottom := color.RGBAModel.Convert(got.At(8, 4)).(color.RGBA), color.RGBAModel.Convert(got.At(8, 27)).(color.RGBA)
+	if top.R < 200 || top.B > 60 || bottom.B < 200 || bottom.R > 60 {
+		t.Errorf("Process() top = %v, bottom = %v, want red on top of blue", top, bottom)
+	}
+}
@@ -0,0 +1,207 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var errTruncated = errors.New("truncated image")
+
+// StripMetadata removes the metadata that may identify the author or the place of a picture,
+// e.g. EXIF with GPS coordinates, XMP, or IPTC, without re-encoding the pixels
+func StripMetadata(mime string, b []byte) ([]byte, error) {
+	switch mime {
+	case "image/jpeg":
+		return stripJPEG(b)
+	case "image/png":
+		return stripPNG(b)
+	case "image/gif":
+		return stripGIF(b)
+	case "image/webp":
+		return stripWebP(b)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// stripJPEG drops the APP1 (EXIF, XMP), APP13 (IPTC), and comment segments
+// the color profile in APP2 and the JFIF header in APP0 are kept
+// the EXIF orientation goes too, Process turns the pixels upright before stripping
+func stripJPEG(b []byte) ([]byte, error) {
+	if len(b) < 2 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(b)))
+	out.Write(b[:2])
+	i := 2
+	for {
+		if i+4 > len(b) || b[i] != 0xFF {
+			return nil, errTruncated
+		}
+		marker := b[i+1]
+		// the compressed data follows the start of scan until the end of the file
+		if marker == 0xDA {
+			out.Write(b[i:])
+			return out.Bytes(), nil
+		}
+
+		length := int(binary.BigEndian.Uint16(b[i+2 : i+4]))
+		end := i + 2 + length
+		if length < 2 || end > len(b) {
+			return nil, errTruncated
+		}
+		switch marker {
+		case 0xE1, 0xED, 0xFE:
+		default:
+			out.Write(b[i:end])
+		}
+		i = end
+	}
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1A\n")
+
+// stripPNG drops the eXIf and the textual chunks
+func stripPNG(b []byte) ([]byte, error) {
+	if !bytes.HasPrefix(b, pngSignature) {
+		return nil, ErrUnsupportedFormat
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(b)))
+	out.Write(pngSignature)
+	i := len(pngSignature)
+	for i < len(b) {
+		if i+8 > len(b) {
+			return nil, errTruncated
+		}
+		length := int(binary.BigEndian.Uint32(b[i : i+4]))
+		typ := string(b[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(b) {
+			return nil, errTruncated
+		}
+		switch typ {
+		case "eXIf", "tEXt", "zTXt", "iTXt", "tIME":
+		default:
+			out.Write(b[i:end])
+		}
+		i = end
+		if typ == "IEND" {
+			break
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// stripGIF drops the comment extensions and the application extensions, e.g. XMP
+// the NETSCAPE2.0 extension that loops the animations is kept
+func stripGIF(b []byte) ([]byte, error) {
+	if len(b) < 13 || (string(b[:6]) != "GIF87a" && string(b[:6]) != "GIF89a") {
+		return nil, ErrUnsupportedFormat
+	}
+
+	// the header and the logical screen descriptor, followed by the global color table
+	i := 13
+	if b[10]&0x80 != 0 {
+		i += 3 << (b[10]&0x07 + 1)
+	}
+	if i > len(b) {
+		return nil, errTruncated
+	}
+	out := bytes.NewBuffer(make([]byte, 0, len(b)))
+	out.Write(b[:i])
+
+	// subBlocks skips the data sub-blocks starting at j up to their terminator
+	subBlocks := func(j int) (int, error) {
+		for j < len(b) && b[j] != 0 {
+			j += 1 + int(b[j])
+		}
+		if j >= len(b) {
+			return 0, errTruncated
+		}
+		return j + 1, nil
+	}
+
+	for i < len(b) {
+		start := i
+		switch b[i] {
+		case 0x3B: // trailer
+			out.WriteByte(0x3B)
+			return out.Bytes(), nil
+		case 0x21: // extension
+			if i+2 > len(b) {
+				return nil, errTruncated
+			}
+			label := b[i+1]
+			end, err := subBlocks(i + 2)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+			switch {
+			case label == 0xFE:
+				continue
+			case label == 0xFF && !bytes.HasPrefix(b[start+2:end], []byte("\x0BNETSCAPE2.0")):
+				continue
+			}
+		case 0x2C: // image descriptor, its local color table, and the lzw data
+			if i+11 > len(b) {
+				return nil, errTruncated
+			}
+			j := i + 10
+			if b[i+9]&0x80 != 0 {
+				j += 3 << (b[i+9]&0x07 + 1)
+			}
+			end, err := subBlocks(j + 1)
+			if err != nil {
+				return nil, err
+			}
+			i = end
+		default:
+			return nil, errTruncated
+		}
+		out.Write(b[start:i])
+	}
+	return nil, errTruncated
+}
+
+// stripWebP drops the EXIF and XMP chunks and clears their flags in the extended header
+func stripWebP(b []byte) ([]byte, error) {
+	if len(b) < 12 || string(b[:4]) != "RIFF" || string(b[8:12]) != "WEBP" {
+		return nil, ErrUnsupportedFormat
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(b)))
+	out.Write(b[:12])
+	i := 12
+	for i < len(b) {
+		if i+8 > len(b) {
+			return nil, errTruncated
+		}
+		typ := string(b[i : i+4])
+		length := int(binary.LittleEndian.Uint32(b[i+4 : i+8]))
+		// the chunks are padded to an even size
+		end := i + 8 + length + length%2
+		if length < 0 || end > len(b) {
+			return nil, errTruncated
+		}
+		switch typ {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte{}, b[i:end]...)
+			if len(chunk) > 8 {
+				// the flags of the exif and the xmp metadata
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out.Write(chunk)
+		default:
+			out.Write(b[i:end])
+		}
+		i = end
+	}
+
+	stripped := out.Bytes()
+	binary.LittleEndian.PutUint32(stripped[4:8], uint32(len(stripped)-8))
+	return stripped, nil
+}
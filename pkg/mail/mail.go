@@ -1,8 +1,7 @@
 package mail
 
 import (
-	"fmt"
-	"net/smtp"
+	"context"
 
 	"github.com/asaskevich/govalidator"
 	"github.com/gocs/errored"
@@ -11,28 +10,37 @@ import (
 const (
 	ErrNotValidFromAddr = errored.New("sender email address provided is invalid")
 	ErrNotValidToAddr   = errored.New("recipient email address provided is invalid")
+	ErrEmptyBody        = errored.New("mail has neither a text nor an html body")
+	ErrUnknownTransport = errored.New("mail transport is unknown")
 )
 
-// Send this sends a mail specifically for gmail using plain auth
-// fromAddr is the sender email address
-// password is the sender password; you must use an App Password
-// toAddr is the recipient email address
-// subject is the subject of the mail
-// body is the content of your mail
-func Send(fromAddr, password, toAddr, subject, body string) error {
-	if !govalidator.IsEmail(fromAddr) {
+// Mailer sends mails from its configured sender address
+type Mailer interface {
+	// Send sends the message to its single recipient
+	// the sender of the message is set by the mailer when it is empty
+	Send(ctx context.Context, msg Message) error
+}
+
+// validate checks the addresses and body of the message
+func (m Message) validate() error {
+	if !govalidator.IsEmail(m.From) {
 		return ErrNotValidFromAddr
 	}
 
-	if !govalidator.IsEmail(toAddr) {
+	if !govalidator.IsEmail(m.To) {
 		return ErrNotValidToAddr
 	}
 
-	msg := fmt.Sprint("From: ", fromAddr, "\n",
-		"To: ", toAddr, "\n",
-		"Subject: ", subject, "\n\n", body)
+	if m.Text == "" && m.HTML == "" {
+		return ErrEmptyBody
+	}
+	return nil
+}
 
-	return smtp.SendMail("smtp.gmail.com:587",
-		smtp.PlainAuth("", fromAddr, password, "smtp.gmail.com"),
-		fromAddr, []string{toAddr}, []byte(msg))
+// withFrom sets the sender if the message has none
+func (m Message) withFrom(from string) Message {
+	if m.From == "" {
+		m.From = from
+	}
+	return m
 }
@@ -0,0 +1,126 @@
+package mail
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Message is a mail handed to a Mailer
+// if both Text and HTML are set, the mail is sent as multipart/alternative
+// so clients that cannot render html show the text instead
+type Message struct {
+	From     string
+	FromName string // optional display name of the sender, may be non-ASCII
+	To       string
+	ToName   string // optional display name of the recipient, may be non-ASCII
+	Subject  string // may be non-ASCII
+	Text     string
+	HTML     string
+
+	// Date defaults to the time the message is built
+	Date time.Time
+}
+
+// Build composes the RFC 5322 message with CRLF line endings
+// non-ASCII headers are encoded as RFC 2047 words and bodies as quoted-printable UTF-8
+func (m Message) Build() ([]byte, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	date := m.Date
+	if date.IsZero() {
+		date = time.Now()
+	}
+	messageID, err := newMessageID(m.From)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageID)
+	header("From", (&mail.Address{Name: m.FromName, Address: m.From}).String())
+	header("To", (&mail.Address{Name: m.ToName, Address: m.To}).String())
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("MIME-Version", "1.0")
+
+	// a single body does not need to be wrapped in a multipart
+	if m.HTML == "" || m.Text == "" {
+		contentType, body := "text/plain; charset=utf-8", m.Text
+		if m.HTML != "" {
+			contentType, body = "text/html; charset=utf-8", m.HTML
+		}
+		header("Content-Type", contentType)
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, body); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	parts := []struct{ contentType, content string }{
+		// the last part is the preferred one
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	}
+	for _, part := range parts {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, part.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	header("Content-Type", mime.FormatMediaType("multipart/alternative", map[string]string{"boundary": mw.Boundary()}))
+	buf.WriteString("\r\n")
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+// writeQuotedPrintable encodes the content, the writer also turns its line breaks into CRLF
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, strings.ReplaceAll(content, "\r\n", "\n")); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// newMessageID generates a unique id on the domain of the sender
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return fmt.Sprintf("<%d.%s@%s>", time.Now().UnixNano(), hex.EncodeToString(b), domain), nil
+}
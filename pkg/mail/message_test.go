@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestMessageBuild(t *testing.T) {
+	msg := Message{
+		From:     "noreply@example.com",
+		FromName: "pensive",
+		To:       "user@example.com",
+		ToName:   "@ñandú",
+		Subject:  "Welcome to pensive, ñandú",
+		Text:     "hello ñandú\nbye",
+		HTML:     "<p>hello ñandú</p>",
+	}
+
+	b, err := msg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(bytes.ReplaceAll(b, []byte("\r\n"), nil), []byte("\n")) {
+		t.Error("Build() has bare LF line endings")
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dec := new(mime.WordDecoder)
+	subject, err := dec.DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject != msg.Subject {
+		t.Errorf("Subject = %q, want %q", subject, msg.Subject)
+	}
+
+	to, err := parsed.Header.AddressList("To")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(to) != 1 || to[0].Name != msg.ToName || to[0].Address != msg.To {
+		t.Errorf("To = %v, want %q <%s>", to, msg.ToName, msg.To)
+	}
+
+	for _, key := range []string{"Date", "Message-ID", "MIME-Version"} {
+		if parsed.Header.Get(key) == "" {
+			t.Errorf("header %s is missing", key)
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %s, want multipart/alternative", mediaType)
+	}
+
+	// the multipart reader decodes the quoted-printable parts
+	mr := multipart.NewReader(parsed.Body, params["boundary"])
+	want := []struct{ contentType, content string }{
+		{"text/plain", msg.Text},
+		{"text/html", msg.HTML},
+	}
+	for _, w := range want {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(part.Header.Get("Content-Type"), w.contentType) {
+			t.Errorf("part Content-Type = %s, want %s", part.Header.Get("Content-Type"), w.contentType)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := strings.ReplaceAll(string(content), "\r\n", "\n")
+		if got != w.content {
+			t.Errorf("part content = %q, want %q", got, w.content)
+		}
+	}
+}
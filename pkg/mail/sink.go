@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Maildir writes every mail as a file in a maildir, this is meant for local development
+// open the directory with any maildir capable client or just read the files in Dir/new
+type Maildir struct {
+	Dir  string
+	From string
+}
+
+// NewMaildir creates the maildir folders if they do not exist
+func NewMaildir(dir, from string) (*Maildir, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, err
+		}
+	}
+	return &Maildir{Dir: dir, From: from}, nil
+}
+
+func (m *Maildir) Send(ctx context.Context, msg Message) error {
+	msg = msg.withFrom(m.From)
+	data, err := msg.Build()
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%d_%s.pensive", time.Now().UnixNano(), os.Getpid(), hex.EncodeToString(b))
+
+	// maildir delivery: write in tmp, then move to new so readers never see a partial mail
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", name))
+}
+
+// Recorder keeps the sent mails in memory, this is meant for tests
+type Recorder struct {
+	From string
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *Recorder) Send(ctx context.Context, msg Message) error {
+	msg = msg.withFrom(m.From)
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of the recorded mails in the order they were sent
+func (m *Recorder) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
@@ -0,0 +1,52 @@
+package mail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMaildir(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewMaildir(dir, "noreply@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Send(context.Background(), Message{To: "user@example.com", Subject: "hello", Text: "world"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Send(context.Background(), Message{To: "not an email", Subject: "hello", Text: "world"}); err != ErrNotValidToAddr {
+		t.Fatalf("Send() error = %v, want %v", err, ErrNotValidToAddr)
+	}
+
+	files, err := os.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("delivered %d mails, want 1", len(files))
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "new", files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "To: <user@example.com>") {
+		t.Errorf("mail = %q, want the recipient header", b)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	m := &Recorder{From: "noreply@example.com"}
+	if err := m.Send(context.Background(), Message{To: "user@example.com", Subject: "hello", Text: "world"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := m.Messages()
+	want := Message{From: "noreply@example.com", To: "user@example.com", Subject: "hello", Text: "world"}
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("Messages() = %v, want [%v]", got, want)
+	}
+}
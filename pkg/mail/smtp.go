@@ -0,0 +1,121 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/asaskevich/govalidator"
+)
+
+// TLSMode is how the connection to the smtp server is secured
+type TLSMode string
+
+const (
+	// TLSNone sends in plain text, only use this for relays on a trusted network
+	TLSNone TLSMode = "none"
+	// TLSStart upgrades the plain connection with STARTTLS, usually on port 587
+	TLSStart TLSMode = "starttls"
+	// TLSImplicit connects with TLS right away, usually on port 465
+	TLSImplicit TLSMode = "tls"
+)
+
+// SMTPConfig configures a generic smtp server
+// Username and Password are optional, leave them empty if the relay needs no auth
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+	TLS      TLSMode
+	Timeout  time.Duration // defaults to 30 seconds
+}
+
+// SMTP sends mails through an smtp server
+type SMTP struct {
+	cfg SMTPConfig
+}
+
+// NewSMTP creates a mailer for the smtp server
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	if !govalidator.IsEmail(cfg.From) {
+		return nil, ErrNotValidFromAddr
+	}
+	if cfg.TLS == "" {
+		cfg.TLS = TLSStart
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &SMTP{cfg: cfg}, nil
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	msg = msg.withFrom(m.cfg.From)
+	data, err := msg.Build()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	// stop the conversation when the context is done
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, m.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if m.cfg.TLS == TLSStart {
+		if err := c.StartTLS(&tls.Config{ServerName: m.cfg.Host}); err != nil {
+			return err
+		}
+	}
+	if m.cfg.Username != "" {
+		auth := smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(msg.From); err != nil {
+		return err
+	}
+	if err := c.Rcpt(msg.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+func (m *SMTP) dial(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(m.cfg.Host, m.cfg.Port)
+	if m.cfg.TLS == TLSImplicit {
+		d := &tls.Dialer{Config: &tls.Config{ServerName: m.cfg.Host}}
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	d := &net.Dialer{}
+	return d.DialContext(ctx, "tcp", addr)
+}
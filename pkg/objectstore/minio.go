@@ -90,25 +90,62 @@ type PresignedGetObjectOptions struct {
 }
 
 // PresignedGetObject gets presigned URL for object
-// if presignedURLExpiration is not set defaults to a day
+// if presignedURLExpiration is not set defaults to ObjectStore.PresignedURLExpiration, then to a day
+// if ReqParams is not set the object is downloaded as an attachment
 func (ostore *ObjectStore) GetPresignedURLObject(ctx context.Context, bucketName, filename string, opts PresignedGetObjectOptions) (*url.URL, error) {
-	ostore.PresignedURLExpiration = opts.PresignedURLExpiration
-	if ostore.PresignedURLExpiration == 0 {
-		ostore.PresignedURLExpiration = time.Second * 24 * 60 * 60
+	expiration := opts.PresignedURLExpiration
+	if expiration == 0 {
+		expiration = ostore.PresignedURLExpiration
+	}
+	if expiration == 0 {
+		expiration = time.Second * 24 * 60 * 60
 	}
 
-	rp := make(url.Values)
-	rp.Set("response-content-disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
-	opts.ReqParams = rp
+	rp := opts.ReqParams
+	if rp == nil {
+		rp = make(url.Values)
+		rp.Set("response-content-disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	}
 
-	return ostore.mc.PresignedGetObject(ctx, bucketName, filename, ostore.PresignedURLExpiration, opts.ReqParams)
+	return ostore.mc.PresignedGetObject(ctx, bucketName, filename, expiration, rp)
 }
 
 // GetObject gets object to be written
+// the object is also an io.ReadSeeker so it can serve range requests
 func (ostore *ObjectStore) GetObject(ctx context.Context, bucketName, filename string) (*minio.Object, error) {
 	return ostore.mc.GetObject(ctx, bucketName, filename, minio.GetObjectOptions{})
 }
 
+// GetObjectRange gets length bytes of the object from offset, a length below 1 reads until the end
+func (ostore *ObjectStore) GetObjectRange(ctx context.Context, bucketName, filename string, offset, length int64) (*minio.Object, error) {
+	opts := minio.GetObjectOptions{}
+	var err error
+	switch {
+	case length > 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case offset > 0:
+		err = opts.SetRange(offset, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ostore.mc.GetObject(ctx, bucketName, filename, opts)
+}
+
+// StatObject gets the metadata of the object, e.g. its content type, size and etag
+func (ostore *ObjectStore) StatObject(ctx context.Context, bucketName, filename string) (minio.ObjectInfo, error) {
+	return ostore.mc.StatObject(ctx, bucketName, filename, minio.StatObjectOptions{})
+}
+
+// IsNotFound tells if the error is about a missing bucket or object
+func IsNotFound(err error) bool {
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return true
+	}
+	return false
+}
+
 type PutObjectOptions minio.PutObjectOptions
 
 // SaveObjectFromPath saves file from local disk to cloud
@@ -122,3 +159,32 @@ func (ostore *ObjectStore) SaveObjectFromPath(ctx context.Context, bucketName, o
 func (ostore *ObjectStore) SaveObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts PutObjectOptions) (minio.UploadInfo, error) {
 	return ostore.mc.PutObject(ctx, bucketName, objectName, reader, objectSize, minio.PutObjectOptions(opts))
 }
+
+// RemoveObject deletes the object from the bucket
+func (ostore *ObjectStore) RemoveObject(ctx context.Context, bucketName, objectName string) error {
+	return ostore.mc.RemoveObject(ctx, bucketName, objectName, minio.RemoveObjectOptions{})
+}
+
+// NewMultipartUpload starts an upload of the object in parts and gives its upload id
+func (ostore *ObjectStore) NewMultipartUpload(ctx context.Context, bucketName, objectName string, opts PutObjectOptions) (string, error) {
+	core := minio.Core{Client: ostore.mc}
+	return core.NewMultipartUpload(ctx, bucketName, objectName, minio.PutObjectOptions(opts))
+}
+
+// PutObjectPart uploads a part of the multipart upload, partNumber starts at 1
+func (ostore *ObjectStore) PutObjectPart(ctx context.Context, bucketName, objectName, uploadID string, partNumber int, reader io.Reader, size int64) (minio.ObjectPart, error) {
+	core := minio.Core{Client: ostore.mc}
+	return core.PutObjectPart(ctx, bucketName, objectName, uploadID, partNumber, reader, size, "", "", nil)
+}
+
+// CompleteMultipartUpload assembles the parts into the object and gives its etag
+func (ostore *ObjectStore) CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, parts []minio.CompletePart) (string, error) {
+	core := minio.Core{Client: ostore.mc}
+	return core.CompleteMultipartUpload(ctx, bucketName, objectName, uploadID, parts, minio.PutObjectOptions{})
+}
+
+// AbortMultipartUpload drops the parts of the multipart upload
+func (ostore *ObjectStore) AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error {
+	core := minio.Core{Client: ostore.mc}
+	return core.AbortMultipartUpload(ctx, bucketName, objectName, uploadID)
+}
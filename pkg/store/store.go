@@ -1,16 +1,141 @@
+// Package store is a client of SeaweedFS: the master assigns file ids and locates volumes,
+// the volume servers keep the files, and the filer keeps them under paths
 package store
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
-	"os"
+	"net/textproto"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
+var (
+	// ErrNotFound is returned when the file, path, or volume does not exist
+	ErrNotFound = errors.New("store: not found")
+	// ErrNoMaster is returned by the master and volume operations when Options.MasterURL is not set
+	ErrNoMaster = errors.New("store: no master url")
+	// ErrNoFiler is returned by the filer operations when Options.FilerURL is not set
+	ErrNoFiler = errors.New("store: no filer url")
+	// ErrInvalidFid is returned for file ids that are not "volume,key"
+	ErrInvalidFid = errors.New("store: invalid file id")
+)
+
+// MetadataPrefix is the header prefix SeaweedFS keeps as the extended attributes of a file
+const MetadataPrefix = "Seaweed-"
+
+// DefaultResponseTimeout is how long the servers have to start answering, the bodies are not limited
+const DefaultResponseTimeout = 30 * time.Second
+
+// Options configures the client, each url is only needed by the operations of its server
+type Options struct {
+	MasterURL string // e.g. "http://localhost:9333"
+	FilerURL  string // e.g. "http://localhost:8888"
+	// HTTPClient defaults to a client that waits DefaultResponseTimeout for the response headers
+	HTTPClient *http.Client
+}
+
+// Client talks to the master, volume, and filer servers
+type Client struct {
+	master *url.URL
+	filer  *url.URL
+	http   *http.Client
+}
+
+// New creates the client
+func New(opts Options) (*Client, error) {
+	c := &Client{http: opts.HTTPClient}
+	var err error
+	if opts.MasterURL != "" {
+		if c.master, err = url.Parse(opts.MasterURL); err != nil {
+			return nil, err
+		}
+	}
+	if opts.FilerURL != "" {
+		if c.filer, err = url.Parse(opts.FilerURL); err != nil {
+			return nil, err
+		}
+	}
+	if c.http == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.ResponseHeaderTimeout = DefaultResponseTimeout
+		c.http = &http.Client{Transport: t}
+	}
+	return c, nil
+}
+
+// do sends the request and turns the error statuses into errors, the caller closes the body
+func (c *Client) do(ctx context.Context, method, u string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("store: %s %s: %s: %s", method, u, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// getJSON decodes the answer of a GET into v
+func (c *Client) getJSON(ctx context.Context, u string, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, u, nil, http.Header{"Accept": {"application/json"}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// serverURL makes an absolute url from the "host:port" given by the master, using the scheme of the master
+func (c *Client) serverURL(server, p string) string {
+	if !strings.Contains(server, "://") {
+		server = c.master.Scheme + "://" + server
+	}
+	return strings.TrimSuffix(server, "/") + "/" + strings.TrimPrefix(p, "/")
+}
+
+// rangeHeader asks for length bytes from offset, a negative length reads until the end
+func rangeHeader(offset, length int64) http.Header {
+	h := http.Header{}
+	switch {
+	case length > 0:
+		h.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		h.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	return h
+}
+
+// AssignOptions are the parameters of an assignment, the empty ones use the defaults of the master
+type AssignOptions struct {
+	Count       int    // how many consecutive file ids to reserve
+	Replication string // e.g. "001"
+	TTL         string // e.g. "3m", "1d"
+	Collection  string
+	DataCenter  string
+}
+
 type AssignResp struct {
 	Fid       string `json:"fid"`
 	URL       string `json:"url"`
@@ -19,17 +144,25 @@ type AssignResp struct {
 	Error     string `json:"error"`
 }
 
-// Assign gets the designated url to store the media
-func Assign(weedAddr string) (*AssignResp, error) {
-	dirurl := fmt.Sprintf("%s/dir/assign", weedAddr)
-	resp, err := http.Get(dirurl)
-	if err != nil {
-		return nil, err
+// Assign gets a file id and the volume server to upload it to
+func (c *Client) Assign(ctx context.Context, opts AssignOptions) (*AssignResp, error) {
+	if c.master == nil {
+		return nil, ErrNoMaster
 	}
-	defer resp.Body.Close()
+	q := url.Values{}
+	if opts.Count > 0 {
+		q.Set("count", strconv.Itoa(opts.Count))
+	}
+	for k, v := range map[string]string{"replication": opts.Replication, "ttl": opts.TTL, "collection": opts.Collection, "dataCenter": opts.DataCenter} {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u := c.master.JoinPath("/dir/assign")
+	u.RawQuery = q.Encode()
 
 	assignment := &AssignResp{}
-	if err := json.NewDecoder(resp.Body).Decode(assignment); err != nil {
+	if err := c.getJSON(ctx, u.String(), assignment); err != nil {
 		return nil, err
 	}
 	if assignment.Error != "" {
@@ -38,66 +171,292 @@ func Assign(weedAddr string) (*AssignResp, error) {
 	return assignment, nil
 }
 
+// Location is a volume server holding a volume
+type Location struct {
+	URL       string `json:"url"`
+	PublicURL string `json:"publicUrl"`
+}
+
+type lookupResp struct {
+	VolumeID  string     `json:"volumeId"`
+	Locations []Location `json:"locations"`
+	Error     string     `json:"error"`
+}
+
+// Lookup finds the volume servers of the volume, fid may be a file id or a volume id
+func (c *Client) Lookup(ctx context.Context, fid string) ([]Location, error) {
+	if c.master == nil {
+		return nil, ErrNoMaster
+	}
+	vid, _, _ := strings.Cut(fid, ",")
+	if vid == "" {
+		return nil, ErrInvalidFid
+	}
+	u := c.master.JoinPath("/dir/lookup")
+	u.RawQuery = url.Values{"volumeId": {vid}}.Encode()
+
+	lookup := &lookupResp{}
+	if err := c.getJSON(ctx, u.String(), lookup); err != nil {
+		return nil, err
+	}
+	if lookup.Error != "" {
+		// the master answers missing volumes with an error message rather than a 404
+		if strings.Contains(lookup.Error, "not found") {
+			return nil, ErrNotFound
+		}
+		return nil, errors.New(lookup.Error)
+	}
+	if len(lookup.Locations) == 0 {
+		return nil, ErrNotFound
+	}
+	return lookup.Locations, nil
+}
+
+// fileURL locates the file on the first volume server holding it
+func (c *Client) fileURL(ctx context.Context, fid string) (string, error) {
+	if !strings.Contains(fid, ",") {
+		return "", ErrInvalidFid
+	}
+	locs, err := c.Lookup(ctx, fid)
+	if err != nil {
+		return "", err
+	}
+	return c.serverURL(locs[0].URL, fid), nil
+}
+
+// File is a file being uploaded
+type File struct {
+	Name        string
+	ContentType string
+	Metadata    map[string]string // kept as extended attributes
+	TTL         string            // the ttl of the assignment when it has one, only used by Upload
+}
+
 type UploadResp struct {
-	Name  int    `json:"name"`
-	Size  int    `json:"size"`
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
 	ETag  string `json:"eTag"`
 	Error string `json:"error"`
 }
 
-// Upload sends the form to the URL
-// courtesy of https://stackoverflow.com/a/20397167/6056991
-func Upload(fidURL string, form map[string]io.Reader) (*UploadResp, error) {
-	// Prepare a form that you will submit to that URL.
-	var b bytes.Buffer
-	w := multipart.NewWriter(&b)
-	for key, r := range form {
-		var fw io.Writer
-		var err error
-		if x, ok := r.(io.Closer); ok {
-			defer x.Close()
+// upload streams r as a multipart form, which is how the volume and filer servers take files
+func (c *Client) upload(ctx context.Context, u string, f File, r io.Reader) (*UploadResp, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="%s"`, escapeQuotes(f.Name)))
+		if f.ContentType != "" {
+			h.Set("Content-Type", f.ContentType)
 		}
-		// Add an image file
-		if x, ok := r.(*os.File); ok {
-			if fw, err = w.CreateFormFile(key, x.Name()); err != nil {
-				return nil, err
-			}
-		} else {
-			// Add other fields
-			if fw, err = w.CreateFormField(key); err != nil {
-				return nil, err
-			}
+		part, err := mw.CreatePart(h)
+		if err == nil {
+			_, err = io.Copy(part, r)
 		}
-		if _, err := io.Copy(fw, r); err != nil {
-			return nil, err
+		if err == nil {
+			err = mw.Close()
 		}
-	}
-	// Don't forget to close the multipart writer.
-	// If you don't close it, your request will be missing the terminating boundary.
-	w.Close()
+		pw.CloseWithError(err)
+	}()
 
-	// Now that you have a form, you can submit it to your handler.
-	req, err := http.NewRequest("POST", fidURL, &b)
-	if err != nil {
-		return nil, err
+	header := http.Header{"Content-Type": {mw.FormDataContentType()}}
+	for k, v := range f.Metadata {
+		header.Set(MetadataPrefix+k, v)
 	}
-	// Don't forget to set the content type, this will contain the boundary.
-	req.Header.Set("Content-Type", w.FormDataContentType())
-
-	// Submit the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := c.do(ctx, http.MethodPost, u, pr, header)
+	// unblock the writer if the request ended before reading the whole body
+	pr.CloseWithError(io.ErrClosedPipe)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
 	upload := &UploadResp{}
-	if err = json.NewDecoder(resp.Body).Decode(upload); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(upload); err != nil {
 		return nil, err
 	}
-
 	if upload.Error != "" {
 		return nil, errors.New(upload.Error)
 	}
 	return upload, nil
 }
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
+
+// Upload sends the file to the volume server of the assignment
+func (c *Client) Upload(ctx context.Context, a *AssignResp, f File, r io.Reader) (*UploadResp, error) {
+	if c.master == nil {
+		return nil, ErrNoMaster
+	}
+	u := c.serverURL(a.URL, a.Fid)
+	if f.TTL != "" {
+		u += "?" + url.Values{"ttl": {f.TTL}}.Encode()
+	}
+	return c.upload(ctx, u, f, r)
+}
+
+// Download reads length bytes of the file from offset, a negative length reads until the end
+func (c *Client) Download(ctx context.Context, fid string, offset, length int64) (io.ReadCloser, error) {
+	u, err := c.fileURL(ctx, fid)
+	if err != nil {
+		return nil, err
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	resp, err := c.do(ctx, http.MethodGet, u, nil, rangeHeader(offset, length))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Delete removes the file from its volume
+func (c *Client) Delete(ctx context.Context, fid string) error {
+	u, err := c.fileURL(ctx, fid)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodDelete, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// filerURL is the url of the path on the filer
+func (c *Client) filerURL(p string) string {
+	return c.filer.JoinPath(path.Clean("/" + p)).String()
+}
+
+// FilerPut stores the file at the path of the filer, the missing directories are created
+func (c *Client) FilerPut(ctx context.Context, p string, f File, r io.Reader) (*UploadResp, error) {
+	if c.filer == nil {
+		return nil, ErrNoFiler
+	}
+	if f.Name == "" {
+		f.Name = path.Base(p)
+	}
+	return c.upload(ctx, c.filerURL(p), f, r)
+}
+
+// FilerGet reads length bytes of the file at the path from offset, a negative length reads until the end
+func (c *Client) FilerGet(ctx context.Context, p string, offset, length int64) (io.ReadCloser, error) {
+	if c.filer == nil {
+		return nil, ErrNoFiler
+	}
+	if length == 0 {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	resp, err := c.do(ctx, http.MethodGet, c.filerURL(p), nil, rangeHeader(offset, length))
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// FileInfo describes a file of the filer
+type FileInfo struct {
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string // the extended attributes, keys are lowercase
+}
+
+// FilerStat describes the file at the path without reading it
+func (c *Client) FilerStat(ctx context.Context, p string) (FileInfo, error) {
+	if c.filer == nil {
+		return FileInfo{}, ErrNoFiler
+	}
+	resp, err := c.do(ctx, http.MethodHead, c.filerURL(p), nil, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	resp.Body.Close()
+
+	info := FileInfo{
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+		ETag:        strings.Trim(resp.Header.Get("Etag"), `"`),
+		Metadata:    map[string]string{},
+	}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.LastModified = t.UTC()
+	}
+	for k, v := range resp.Header {
+		if strings.HasPrefix(k, MetadataPrefix) && len(v) > 0 {
+			info.Metadata[strings.ToLower(strings.TrimPrefix(k, MetadataPrefix))] = v[0]
+		}
+	}
+	return info, nil
+}
+
+// FilerDelete removes the file or the directory at the path
+// directories that are not empty are only removed when recursive is set
+func (c *Client) FilerDelete(ctx context.Context, p string, recursive bool) error {
+	if c.filer == nil {
+		return ErrNoFiler
+	}
+	u := c.filerURL(p)
+	if recursive {
+		u += "?" + url.Values{"recursive": {"true"}}.Encode()
+	}
+	resp, err := c.do(ctx, http.MethodDelete, u, nil, nil)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// Entry is a file or a directory listed by the filer
+type Entry struct {
+	FullPath string    `json:"FullPath"`
+	Mtime    time.Time `json:"Mtime"`
+	Mode     uint32    `json:"Mode"`
+	Mime     string    `json:"Mime"`
+	FileSize int64     `json:"FileSize"`
+}
+
+// dirMode is the bit of Entry.Mode set on directories, the same as os.ModeDir
+const dirMode = 1 << 31
+
+// IsDir tells if the entry is a directory
+func (e Entry) IsDir() bool { return e.Mode&dirMode != 0 }
+
+// Listing is a page of a directory listing
+type Listing struct {
+	Path                  string  `json:"Path"`
+	Entries               []Entry `json:"Entries"`
+	LastFileName          string  `json:"LastFileName"`
+	ShouldDisplayLoadMore bool    `json:"ShouldDisplayLoadMore"`
+}
+
+// FilerList lists up to limit entries of the directory after the entry named lastFileName
+// the next page is listed with the LastFileName of the listing while ShouldDisplayLoadMore is set
+func (c *Client) FilerList(ctx context.Context, dir, lastFileName string, limit int) (*Listing, error) {
+	if c.filer == nil {
+		return nil, ErrNoFiler
+	}
+	q := url.Values{}
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
+	if lastFileName != "" {
+		q.Set("lastFileName", lastFileName)
+	}
+	u := strings.TrimSuffix(c.filerURL(dir), "/") + "/"
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+
+	listing := &Listing{}
+	if err := c.getJSON(ctx, u, listing); err != nil {
+		return nil, err
+	}
+	return listing, nil
+}
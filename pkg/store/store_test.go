@@ -0,0 +1,285 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testFile struct {
+	data []byte
+	mime string
+	meta http.Header
+}
+
+// fakeServer stands in for a master with a single volume, a volume server, and a filer
+// the files of the volume server and the filer are both kept by their url path
+type fakeServer struct {
+	t       *testing.T
+	volume  *httptest.Server
+	master  *httptest.Server
+	filer   *httptest.Server
+	mu      sync.Mutex
+	files   map[string]testFile
+	assigns []string // the queries of the assignments
+	nextKey int
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s := &fakeServer{t: t, files: map[string]testFile{}}
+	s.volume = httptest.NewServer(http.HandlerFunc(s.serveFiles))
+	s.filer = httptest.NewServer(http.HandlerFunc(s.serveFiles))
+	s.master = httptest.NewServer(http.HandlerFunc(s.serveMaster))
+	t.Cleanup(func() {
+		s.volume.Close()
+		s.filer.Close()
+		s.master.Close()
+	})
+	return s
+}
+
+func (s *fakeServer) serveMaster(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the master gives the volume server without a scheme
+	volume := strings.TrimPrefix(s.volume.URL, "http://")
+	switch r.URL.Path {
+	case "/dir/assign":
+		s.assigns = append(s.assigns, r.URL.RawQuery)
+		s.nextKey++
+		json.NewEncoder(w).Encode(AssignResp{Fid: fmt.Sprintf("3,%02x", s.nextKey), URL: volume, PublicURL: volume, Count: 1})
+	case "/dir/lookup":
+		if r.URL.Query().Get("volumeId") != "3" {
+			json.NewEncoder(w).Encode(lookupResp{VolumeID: r.URL.Query().Get("volumeId"), Error: "volume id not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(lookupResp{VolumeID: "3", Locations: []Location{{URL: volume, PublicURL: volume}}})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *fakeServer) serveFiles(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch r.Method {
+	case http.MethodPost:
+		f, fh, err := r.FormFile("file")
+		if err != nil {
+			s.t.Errorf("upload: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(f)
+		meta := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, MetadataPrefix) {
+				meta[k] = v
+			}
+		}
+		s.files[r.URL.Path] = testFile{data: data, mime: fh.Header.Get("Content-Type"), meta: meta}
+		json.NewEncoder(w).Encode(UploadResp{Name: fh.Filename, Size: int64(len(data)), ETag: "etag"})
+	case http.MethodGet, http.MethodHead:
+		if strings.HasSuffix(r.URL.Path, "/") {
+			s.list(w, r)
+			return
+		}
+		f, ok := s.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		for k, v := range f.meta {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Type", f.mime)
+		w.Header().Set("Etag", `"etag"`)
+		http.ServeContent(w, r, "", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), bytes.NewReader(f.data))
+	case http.MethodDelete:
+		if _, ok := s.files[r.URL.Path]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(s.files, r.URL.Path)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// list lists the files right under the directory, two per page
+func (s *fakeServer) list(w http.ResponseWriter, r *http.Request) {
+	names := []string{}
+	for p := range s.files {
+		if name, ok := strings.CutPrefix(p, r.URL.Path); ok && !strings.Contains(name, "/") && name > r.URL.Query().Get("lastFileName") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 && r.URL.Query().Get("lastFileName") == "" {
+		http.NotFound(w, r)
+		return
+	}
+	sort.Strings(names)
+
+	listing := Listing{Path: r.URL.Path}
+	for i, name := range names {
+		if i == 2 {
+			listing.ShouldDisplayLoadMore = true
+			break
+		}
+		p := r.URL.Path + name
+		listing.Entries = append(listing.Entries, Entry{FullPath: p, Mime: s.files[p].mime, FileSize: int64(len(s.files[p].data))})
+		listing.LastFileName = name
+	}
+	json.NewEncoder(w).Encode(listing)
+}
+
+func TestVolume(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeServer(t)
+	c, err := New(Options{MasterURL: s.master.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := c.Assign(ctx, AssignOptions{Replication: "001", TTL: "1d", Collection: "media"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "collection=media&replication=001&ttl=1d"; s.assigns[0] != want {
+		t.Errorf("Assign() query = %q, want %q", s.assigns[0], want)
+	}
+
+	body := "hello, volume"
+	up, err := c.Upload(ctx, a, File{Name: "hello.txt", ContentType: "text/plain"}, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if up.Name != "hello.txt" || up.Size != int64(len(body)) {
+		t.Errorf("Upload() = %+v", up)
+	}
+
+	tests := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, -1, body},
+		{7, -1, "volume"},
+		{0, 5, "hello"},
+		{3, 0, ""},
+	}
+	for _, tt := range tests {
+		r, err := c.Download(ctx, a.Fid, tt.offset, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(r)
+		r.Close()
+		if string(b) != tt.want {
+			t.Errorf("Download(%d, %d) = %q, want %q", tt.offset, tt.length, b, tt.want)
+		}
+	}
+
+	if err := c.Delete(ctx, a.Fid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Download(ctx, a.Fid, 0, -1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Download() after Delete() error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := c.Lookup(ctx, "9,01"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Lookup() of a missing volume error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := c.Download(ctx, "3", 0, -1); !errors.Is(err, ErrInvalidFid) {
+		t.Errorf("Download() of a volume id error = %v, want %v", err, ErrInvalidFid)
+	}
+	if _, err := c.FilerStat(ctx, "/a"); !errors.Is(err, ErrNoFiler) {
+		t.Errorf("FilerStat() without a filer error = %v, want %v", err, ErrNoFiler)
+	}
+}
+
+func TestFiler(t *testing.T) {
+	ctx := context.Background()
+	s := newFakeServer(t)
+	c, err := New(Options{FilerURL: s.filer.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		f := File{ContentType: "text/plain", Metadata: map[string]string{"filename": name + ".txt"}}
+		if _, err := c.FilerPut(ctx, "/media/1/"+name, f, strings.NewReader("file "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := c.FilerStat(ctx, "/media/1/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size != 6 || info.ContentType != "text/plain" || info.ETag != "etag" || info.Metadata["filename"] != "b.txt" || info.LastModified.IsZero() {
+		t.Errorf("FilerStat() = %+v", info)
+	}
+
+	r, err := c.FilerGet(ctx, "/media/1/c", 5, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(r)
+	r.Close()
+	if string(b) != "c" {
+		t.Errorf("FilerGet(5, 1) = %q, want %q", b, "c")
+	}
+
+	// the listing is paged by the name of the last entry
+	names, last := []string{}, ""
+	for {
+		listing, err := c.FilerList(ctx, "/media/1", last, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range listing.Entries {
+			names = append(names, e.FullPath)
+		}
+		if !listing.ShouldDisplayLoadMore {
+			break
+		}
+		last = listing.LastFileName
+	}
+	if got := strings.Join(names, ","); got != "/media/1/a,/media/1/b,/media/1/c" {
+		t.Errorf("FilerList() = %s", got)
+	}
+
+	if err := c.FilerDelete(ctx, "/media/1/a", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.FilerDelete(ctx, "/media/1/a", false); !errors.Is(err, ErrNotFound) {
+		t.Errorf("FilerDelete() of a missing file error = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := c.Assign(ctx, AssignOptions{}); !errors.Is(err, ErrNoMaster) {
+		t.Errorf("Assign() without a master error = %v, want %v", err, ErrNoMaster)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "volume is read only", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	c, err := New(Options{FilerURL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.FilerPut(context.Background(), "/a", File{}, strings.NewReader("a"))
+	if err == nil || !strings.Contains(err.Error(), "volume is read only") {
+		t.Errorf("FilerPut() error = %v, want the message of the server", err)
+	}
+}
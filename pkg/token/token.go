@@ -1,54 +1,250 @@
 package token
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
-	"github.com/gocs/errored"
 	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/redis/go-redis/v9"
 )
 
-// Create will generate a token to be verified
-// signingKey should be auto-generated and should be kept secret
-// userID will be used to verify users
-func Create(signingKey string, userID string) (string, error) {
-	var err error
-	atClaims := jwt.MapClaims{}
-	atClaims["authorized"] = true
-	atClaims["user_id"] = userID
-	atClaims["exp"] = time.Now().Add(time.Minute * 15).Unix()
-	at := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
-	token, err := at.SignedString([]byte(signingKey))
+// Purpose is what a token is minted for; a token is only accepted for its own purpose
+type Purpose string
+
+const (
+	PurposeEmailVerify   Purpose = "email-verify"
+	PurposePasswordReset Purpose = "password-reset"
+	PurposeEmailChange   Purpose = "email-change"
+	PurposeInvite        Purpose = "invite"
+)
+
+// DefaultTTLs is how long the tokens of each purpose are valid when the issuer is not told otherwise
+var DefaultTTLs = map[Purpose]time.Duration{
+	PurposeEmailVerify:   24 * time.Hour,
+	PurposePasswordReset: 30 * time.Minute,
+	PurposeEmailChange:   24 * time.Hour,
+	PurposeInvite:        7 * 24 * time.Hour,
+}
+
+var (
+	// ErrNoKeys is returned when an issuer is created without signing keys
+	ErrNoKeys = errors.New("no signing keys")
+	// ErrUnknownPurpose is returned when a purpose has no ttl
+	ErrUnknownPurpose = errors.New("unknown token purpose")
+	// ErrInvalidToken is returned when a token is malformed, expired, or not signed by a known key
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrWrongPurpose is returned when a token is used for another purpose than the one it was minted for
+	ErrWrongPurpose = errors.New("token was minted for another purpose")
+	// ErrTokenUsed is returned when a token was already consumed
+	ErrTokenUsed = errors.New("token was already used")
+	// ErrBindingChanged is returned when the state a token is bound to has changed since it was minted
+	ErrBindingChanged = errors.New("token is no longer valid")
+)
+
+// Key is a signing secret identified by the kid header of the tokens it signs
+type Key struct {
+	ID     string
+	Secret string
+}
+
+// ParseKeys parses keys written as "kid:secret,kid:secret"
+// the first key signs new tokens, the others are only accepted so keys can be rotated
+func ParseKeys(s string) ([]Key, error) {
+	keys := []Key{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("token key %q is not kid:secret", id)
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	return keys, nil
+}
+
+// Binding is given the subject of a token and returns the current fingerprint of its state,
+// e.g. the hash of the password; once it changes the tokens bound to the old one are invalid
+type Binding func(subject string) (string, error)
+
+// Issuer mints and consumes purpose-scoped tokens
+// each token carries a jti recorded in redis until it is consumed or expires
+type Issuer struct {
+	c    redis.Cmdable
+	keys []Key
+	ttls map[Purpose]time.Duration
+}
+
+// NewIssuer creates an issuer that signs with the first key and accepts all of them
+// ttls overrides DefaultTTLs per purpose, it may be nil
+func NewIssuer(c redis.Cmdable, keys []Key, ttls map[Purpose]time.Duration) (*Issuer, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	merged := map[Purpose]time.Duration{}
+	for p, ttl := range DefaultTTLs {
+		merged[p] = ttl
+	}
+	for p, ttl := range ttls {
+		if ttl > 0 {
+			merged[p] = ttl
+		}
+	}
+	return &Issuer{c: c, keys: keys, ttls: merged}, nil
+}
+
+func jtiKey(jti string) string { return "token:jti:" + jti }
+
+// TTL is how long the tokens of the purpose are valid
+func (i *Issuer) TTL(purpose Purpose) time.Duration { return i.ttls[purpose] }
+
+// Issue mints a token for the purpose
+// subject is who the token is for, usually the user id
+// binding is the fingerprint of the subject state, it may be empty for unbound tokens
+func (i *Issuer) Issue(ctx context.Context, purpose Purpose, subject, binding string) (string, error) {
+	ttl, ok := i.ttls[purpose]
+	if !ok {
+		return "", ErrUnknownPurpose
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	jti := base64.RawURLEncoding.EncodeToString(b)
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"jti":     jti,
+		"sub":     subject,
+		"purpose": string(purpose),
+		"iat":     now.Unix(),
+		"exp":     now.Add(ttl).Unix(),
+	}
+	if binding != "" {
+		claims["binding"] = binding
+	}
+	tokenStr, err := sign(i.keys[0], claims)
+	if err != nil {
+		return "", err
+	}
+
+	if err := i.c.Set(ctx, jtiKey(jti), string(purpose), ttl).Err(); err != nil {
+		return "", err
+	}
+	return tokenStr, nil
+}
+
+// Check verifies a token for the purpose without consuming it and returns its subject
+// binding may be nil for unbound tokens
+func (i *Issuer) Check(ctx context.Context, purpose Purpose, tokenStr string, binding Binding) (string, error) {
+	claims, err := i.verify(purpose, tokenStr, binding)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := i.c.Exists(ctx, jtiKey(claims.jti)).Result()
 	if err != nil {
 		return "", err
 	}
-	return token, nil
+	if n == 0 {
+		return "", ErrTokenUsed
+	}
+	return claims.subject, nil
+}
+
+// Consume verifies a token for the purpose and marks it used so it cannot be replayed
+// only one of concurrent consumers of the same token succeeds
+// binding may be nil for unbound tokens
+func (i *Issuer) Consume(ctx context.Context, purpose Purpose, tokenStr string, binding Binding) (string, error) {
+	claims, err := i.verify(purpose, tokenStr, binding)
+	if err != nil {
+		return "", err
+	}
+
+	n, err := i.c.Del(ctx, jtiKey(claims.jti)).Result()
+	if err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return "", ErrTokenUsed
+	}
+	return claims.subject, nil
 }
 
-// Verify 
-// signingKey should be auto-generated and should be kept secret
-// tokenStr is the string generated from the Create function
-// userID is used to verify users
-func Verify(signingKey, tokenStr string, userID string) error {
+type verified struct {
+	jti     string
+	subject string
+}
+
+func (i *Issuer) verify(purpose Purpose, tokenStr string, binding Binding) (verified, error) {
+	claims, err := parse(i.keys, tokenStr)
+	if err != nil {
+		return verified{}, err
+	}
+
+	if p, _ := claims["purpose"].(string); Purpose(p) != purpose {
+		return verified{}, ErrWrongPurpose
+	}
+	jti, _ := claims["jti"].(string)
+	subject, _ := claims["sub"].(string)
+	if jti == "" || subject == "" {
+		return verified{}, ErrInvalidToken
+	}
+
+	bindingClaims, _ := claims["binding"].(string)
+	if binding != nil {
+		current, err := binding(subject)
+		if err != nil {
+			return verified{}, err
+		}
+		if bindingClaims == "" || subtle.ConstantTimeCompare([]byte(bindingClaims), []byte(current)) != 1 {
+			return verified{}, ErrBindingChanged
+		}
+	}
+	return verified{jti: jti, subject: subject}, nil
+}
+
+// sign signs the claims with the key and sets its id in the kid header
+func sign(key Key, claims jwt.MapClaims) (string, error) {
+	at := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	at.Header["kid"] = key.ID
+	return at.SignedString([]byte(key.Secret))
+}
+
+// parse checks the signature with the key named by the kid header and the expiry of the token
+func parse(keys []Key, tokenStr string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-		return []byte(signingKey), nil
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range keys {
+			if key.ID == kid {
+				return []byte(key.Secret), nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id: %q", kid)
 	})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
-		return errored.New("user is not authorized")
-	}
-
-	userIDClaims := fmt.Sprint(claims["user_id"])
-	if userIDClaims != userID {
-		return errored.New("claims doesn't match the expected user")
+		return nil, ErrInvalidToken
 	}
-	return nil
+	return claims, nil
 }
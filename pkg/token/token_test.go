@@ -0,0 +1,158 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    []Key
+		wantErr bool
+	}{
+		{name: "single", s: "a:one", want: []Key{{"a", "one"}}},
+		{name: "rotation", s: "b:two, a:one", want: []Key{{"b", "two"}, {"a", "one"}}},
+		{name: "secret with colon", s: "a:o:ne", want: []Key{{"a", "o:ne"}}},
+		{name: "empty", s: "", wantErr: true},
+		{name: "no secret", s: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKeys(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseKeys() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ParseKeys() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIssuerVerify(t *testing.T) {
+	oldKey := Key{ID: "old", Secret: "soopa-shiikurrets"}
+	newKey := Key{ID: "new", Secret: "soopa-shiikurrets-too"}
+	// new tokens are signed with "new" while "old" is still accepted
+	i := &Issuer{keys: []Key{newKey, oldKey}, ttls: DefaultTTLs}
+
+	mint := func(key Key, purpose Purpose, binding string, ttl time.Duration) string {
+		claims := jwt.MapClaims{
+			"jti":     "jti",
+			"sub":     "1",
+			"purpose": string(purpose),
+			"exp":     time.Now().Add(ttl).Unix(),
+		}
+		if binding != "" {
+			claims["binding"] = binding
+		}
+		s, err := sign(key, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	bound := func(binding string) Binding {
+		return func(string) (string, error) { return binding, nil }
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		purpose Purpose
+		binding Binding
+		wantErr error
+	}{
+		{name: "valid", token: mint(newKey, PurposePasswordReset, "hash-a", time.Minute), purpose: PurposePasswordReset, binding: bound("hash-a")},
+		{name: "rotated key", token: mint(oldKey, PurposePasswordReset, "hash-a", time.Minute), purpose: PurposePasswordReset, binding: bound("hash-a")},
+		{name: "unbound", token: mint(newKey, PurposeEmailVerify, "", time.Minute), purpose: PurposeEmailVerify},
+		{name: "unknown key", token: mint(Key{ID: "gone", Secret: "x"}, PurposePasswordReset, "hash-a", time.Minute), purpose: PurposePasswordReset, binding: bound("hash-a"), wantErr: ErrInvalidToken},
+		{name: "forged kid", token: mint(Key{ID: "new", Secret: "x"}, PurposePasswordReset, "hash-a", time.Minute), purpose: PurposePasswordReset, binding: bound("hash-a"), wantErr: ErrInvalidToken},
+		{name: "expired", token: mint(newKey, PurposePasswordReset, "hash-a", -time.Minute), purpose: PurposePasswordReset, binding: bound("hash-a"), wantErr: ErrInvalidToken},
+		{name: "wrong purpose", token: mint(newKey, PurposeEmailVerify, "hash-a", time.Minute), purpose: PurposePasswordReset, binding: bound("hash-a"), wantErr: ErrWrongPurpose},
+		{name: "binding changed", token: mint(newKey, PurposePasswordReset, "hash-a", time.Minute), purpose: PurposePasswordReset, binding: bound("hash-b"), wantErr: ErrBindingChanged},
+		{name: "binding missing", token: mint(newKey, PurposePasswordReset, "", time.Minute), purpose: PurposePasswordReset, binding: bound("hash-a"), wantErr: ErrBindingChanged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := i.verify(tt.purpose, tt.token, tt.binding)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got.subject != "1" || got.jti != "jti") {
+				t.Errorf("verify() = %+v", got)
+			}
+		})
+	}
+}
+
+// memRedis keeps the jti keys in memory, it has only the commands the issuer uses
+type memRedis struct {
+	redis.Cmdable
+	keys map[string]string
+}
+
+func (m *memRedis) Set(ctx context.Context, key string, value interface{}, _ time.Duration) *redis.StatusCmd {
+	m.keys[key] = value.(string)
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (m *memRedis) Exists(ctx context.Context, keys ...string) *redis.IntCmd {
+	n := int64(0)
+	for _, key := range keys {
+		if _, ok := m.keys[key]; ok {
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
+func (m *memRedis) Del(ctx context.Context, keys ...string) *redis.IntCmd {
+	n := int64(0)
+	for _, key := range keys {
+		if _, ok := m.keys[key]; ok {
+			delete(m.keys, key)
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
+func TestIssuerConsume(t *testing.T) {
+	ctx := context.Background()
+	i, err := NewIssuer(&memRedis{keys: map[string]string{}}, []Key{{ID: "a", Secret: "soopa-shiikurrets"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenStr, err := i.Issue(ctx, PurposeEmailVerify, "1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checking leaves the token usable
+	for n := 0; n < 2; n++ {
+		if sub, err := i.Check(ctx, PurposeEmailVerify, tokenStr, nil); err != nil || sub != "1" {
+			t.Fatalf("Check() = %q, %v", sub, err)
+		}
+	}
+	if sub, err := i.Consume(ctx, PurposeEmailVerify, tokenStr, nil); err != nil || sub != "1" {
+		t.Fatalf("Consume() = %q, %v", sub, err)
+	}
+	if _, err := i.Consume(ctx, PurposeEmailVerify, tokenStr, nil); !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("second Consume() error = %v, want %v", err, ErrTokenUsed)
+	}
+	if _, err := i.Check(ctx, PurposeEmailVerify, tokenStr, nil); !errors.Is(err, ErrTokenUsed) {
+		t.Fatalf("Check() after Consume() error = %v, want %v", err, ErrTokenUsed)
+	}
+}
@@ -3,23 +3,56 @@ package pensive
 import "time"
 
 type Post struct {
-	ID      int64 // required, nonzero
-	User    User  // required, nonzero
-	Body    string
-	MediaID string
+	ID          int64 // required, nonzero
+	User        User  // required, nonzero
+	Body        string
+	Attachments []Media // in the order they were uploaded
 
 	// do not manually fill-in the ff.
 	CreatedAt *time.Time // required, nonzero
 	UpdatedAt *time.Time // required, nonzero
 }
 
+// Media is an attachment of a post
+type Media struct {
+	ID   string `json:"id"`   // object name in the bucket, generated so uploads never collide
+	Type string `json:"type"` // sniffed MIME type
+	Name string `json:"name"` // original filename of the upload, only used for display and downloads
+	Size int64  `json:"size"`
+	Alt  string `json:"alt,omitempty"`
+
+	// only set for pictures
+	Width    int            `json:"width,omitempty"`
+	Height   int            `json:"height,omitempty"`
+	Variants []MediaVariant `json:"variants,omitempty"` // resized copies stored next to the media
+}
+
+// MediaVariant is a resized copy of a picture, e.g. its thumbnail
+type MediaVariant struct {
+	Name   string `json:"name"`
+	ID     string `json:"id"` // object name in the bucket
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
 // PostPublic is the post displayed through the site
 type PostPublic struct {
-	User           string
-	Caption        string
-	AttachmentURL  string
-	AttachmentType string
-	UpdatedAt      string
+	ID          int64              `json:"id"`
+	User        string             `json:"user"`
+	Caption     string             `json:"caption"`
+	Attachments []AttachmentPublic `json:"attachments"`
+	UpdatedAt   string             `json:"updated_at"`
+}
+
+// AttachmentPublic is a media of a post displayed through the site
+type AttachmentPublic struct {
+	URL  string `json:"url"`
+	Type string `json:"type"` // "image", "audio", "video", or "text"
+	Name string `json:"name"`
+	Size int64  `json:"size,omitempty"`
+	Alt  string `json:"alt,omitempty"`
+	// Srcset lists the resized copies of a picture as an html srcset
+	Srcset string `json:"srcset,omitempty"`
 }
 
 // updates list
@@ -26,6 +26,7 @@ import (
 
 	"github.com/gocs/pensive"
 	"github.com/gocs/pensive/internal/manager"
+	sessions "github.com/gocs/pensive/internal/session"
 )
 
 //go:embed html
@@ -36,18 +37,23 @@ var assets embed.FS
 
 var (
 	home         = parse("html/home.html")
+	post         = parse("html/post.html")
 	userLogin    = parse("html/user/login.html")
 	userRegister = parse("html/user/register.html")
+	forgot       = parse("html/user/forgot.html")
+	reset        = parse("html/user/reset.html")
 	settings     = parse("html/user/settings.html")
 	profile      = parse("html/user/settings/profile.html")
 	privacy      = parse("html/user/settings/privacy.html")
 	account      = parse("html/user/settings/account.html")
+	tokens       = parse("html/user/settings/tokens.html")
 )
 
 func parse(file string) *template.Template {
 	return template.Must(template.New("layout.html").ParseFS(html,
 		"html/layout.html",
 		"html/header.html",
+		"html/postcard.html",
 		file))
 }
 
@@ -56,10 +62,32 @@ type HomeParams struct {
 	Name        string
 	DisplayForm bool
 	Posts       []pensive.PostPublic
+	OlderURL    string        // link to the next older page, empty if there is none
+	NewerURL    string        // link to the next newer page, empty if there is none
+	Profile     *FollowParams // set only when showing a user's profile
+	// QuotaExceeded is set when the last post of the user did not fit in their storage
+	QuotaExceeded *StorageParams
+}
+
+type FollowParams struct {
+	Username    string
+	Followers   int64
+	Following   int64
+	IsSelf      bool
+	IsFollowing bool
 }
 
 func Home(w io.Writer, p HomeParams) error { return home.Execute(w, p) }
 
+type PostParams struct {
+	Title   string
+	Name    string
+	Post    pensive.PostPublic
+	IsOwner bool
+}
+
+func Post(w io.Writer, p PostParams) error { return post.Execute(w, p) }
+
 type UserLoginParams struct{}
 
 func UserLogin(w io.Writer, p UserLoginParams) error { return userLogin.Execute(w, p) }
@@ -68,6 +96,18 @@ type UserRegisterParams struct{}
 
 func UserRegister(w io.Writer, p UserRegisterParams) error { return userRegister.Execute(w, p) }
 
+type ForgotParams struct {
+	Sent bool
+}
+
+func Forgot(w io.Writer, p ForgotParams) error { return forgot.Execute(w, p) }
+
+type ResetParams struct {
+	Token string
+}
+
+func Reset(w io.Writer, p ResetParams) error { return reset.Execute(w, p) }
+
 type SettingsParams struct {
 	Title string
 	Name  string
@@ -77,30 +117,54 @@ type SettingsParams struct {
 func Settings(w io.Writer, p SettingsParams) error { return settings.Execute(w, p) }
 
 type ProfileParams struct {
-	Title string
-	Name  string
-	User  *manager.User
+	Title       string
+	Name        string
+	User        *manager.User
+	RequiresAlt bool
 }
 
 func Profile(w io.Writer, p ProfileParams) error { return profile.Execute(w, p) }
 
 type PrivacyParams struct {
-	Title string
-	Name  string
-	User  *manager.User
+	Title           string
+	Name            string
+	User            *manager.User
+	CanListSessions bool
+	Sessions        []sessions.Info
 }
 
 func Privacy(w io.Writer, p PrivacyParams) error { return privacy.Execute(w, p) }
 
 type AccountParams struct {
-	Title      string
-	Name       string
-	User       *manager.User
-	IsVerified bool
+	Title        string
+	Name         string
+	User         *manager.User
+	IsVerified   bool
+	PendingEmail string
+	Storage      StorageParams
+}
+
+// StorageParams is the storage used by the user against their quota
+type StorageParams struct {
+	Used        string // e.g. "1.5 MiB"
+	Limit       string // empty when the size is unlimited
+	Percent     int    // of the larger of the size and the number of files used
+	Objects     int64
+	ObjectLimit int64 // zero when the number of files is unlimited
 }
 
 func Account(w io.Writer, p AccountParams) error { return account.Execute(w, p) }
 
+type TokensParams struct {
+	Title    string
+	Name     string
+	User     *manager.User
+	Tokens   []manager.APIToken
+	NewToken string // the raw token that was just created, shown only once
+}
+
+func Tokens(w io.Writer, p TokensParams) error { return tokens.Execute(w, p) }
+
 func AssetsFS() http.Handler {
 	// css and js files
 	fs := http.FileServer(http.FS(assets))
@@ -0,0 +1,79 @@
+package tmpl
+
+import (
+	"bytes"
+	"embed"
+	"html/template"
+	texttemplate "text/template"
+)
+
+// mail templates come in pairs: a plain text "<name>.txt" and an html "<name>.html" using "mail/layout.html"
+
+//go:embed mail
+var mails embed.FS
+
+var (
+	verifyMail = parseMail("verify")
+	resetMail  = parseMail("reset")
+
+	emailChangeMail = parseMail("email_change")
+	emailNoticeMail = parseMail("email_notice")
+)
+
+type mailTemplate struct {
+	text *texttemplate.Template
+	html *template.Template
+}
+
+func parseMail(name string) mailTemplate {
+	return mailTemplate{
+		text: texttemplate.Must(texttemplate.New(name+".txt").ParseFS(mails, "mail/"+name+".txt")),
+		html: template.Must(template.New("layout.html").ParseFS(mails, "mail/layout.html", "mail/"+name+".html")),
+	}
+}
+
+// execute renders both the text and the html body of the mail
+func (t mailTemplate) execute(p interface{}) (text, html string, err error) {
+	var tb, hb bytes.Buffer
+	if err := t.text.Execute(&tb, p); err != nil {
+		return "", "", err
+	}
+	if err := t.html.Execute(&hb, p); err != nil {
+		return "", "", err
+	}
+	return tb.String(), hb.String(), nil
+}
+
+type VerifyMailParams struct {
+	Username string
+	Link     string
+}
+
+func VerifyMail(p VerifyMailParams) (text, html string, err error) { return verifyMail.execute(p) }
+
+type ResetMailParams struct {
+	Username string
+	Link     string
+	Expires  string
+}
+
+func ResetMail(p ResetMailParams) (text, html string, err error) { return resetMail.execute(p) }
+
+type EmailChangeMailParams struct {
+	Username string
+	Link     string
+	Expires  string
+}
+
+func EmailChangeMail(p EmailChangeMailParams) (text, html string, err error) {
+	return emailChangeMail.execute(p)
+}
+
+type EmailNoticeMailParams struct {
+	Username string
+	NewEmail string
+}
+
+func EmailNoticeMail(p EmailNoticeMailParams) (text, html string, err error) {
+	return emailNoticeMail.execute(p)
+}